@@ -1,87 +1,298 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	utils "github.com/sklyerx/hideaway/utils"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-var addCmd = &cobra.Command{
-	Use:   "add <file>",
-	Short: "Add a file to Hideaway",
-	Args:  cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		deleteOriginal, _ := cmd.Flags().GetBool("delete")
-		newName, _ := cmd.Flags().GetString("name")
-
-		path := args[0]
-
-		if strings.HasPrefix(path, "'") && strings.HasSuffix(path, "'") {
-			path = strings.TrimPrefix(path, "'")
-			path = strings.TrimSuffix(path, "'")
-		} else if strings.HasPrefix(path, "\"") && strings.HasSuffix(path, "\"") {
-			path = strings.TrimPrefix(path, "\"")
-			path = strings.TrimSuffix(path, "\"")
-		}
+func newAddCmd() *cobra.Command {
+	addCmd := &cobra.Command{
+		Use:     "add <file>...",
+		Short:   "Add a file to Hideaway",
+		Long:    "Encrypt one or more files and store them in your vault. Glob patterns like *.pdf are expanded for you inside the repl. With -r, add a whole directory as a collection that can be retrieved with its structure intact. Use - together with --name to store whatever is piped into stdin",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deleteOriginal, _ := cmd.Flags().GetBool("delete")
+			separate, _ := cmd.Flags().GetBool("new")
+			newName, _ := cmd.Flags().GetString("name")
+			recursive, _ := cmd.Flags().GetBool("recursive")
+
+			filePaths := utils.GetAppPaths()
+			dumpPath := filepath.Join(filePaths["userData"], "dump")
+
+			if slices.Contains(args, "-") {
+				if len(args) > 1 {
+					return errors.New("- reads the file from stdin and can't be combined with other files")
+				}
+
+				if newName == "" {
+					return errors.New("adding from stdin needs a --name")
+				}
+
+				return addStdin(dumpPath, newName, !separate)
+			}
+
+			var paths []string
+			var err error
+			failed, dirsAdded := 0, 0
+
+			for _, arg := range args {
+				resolved, err := expandAddArg(arg)
+				if err != nil {
+					color.Red("[ FAILED ] %s: %v", arg, err)
+					failed++
+					continue
+				}
+				paths = append(paths, resolved...)
+			}
+
+			if newName != "" && len(paths) > 1 {
+				return fmt.Errorf("--name can only be used when adding a single file")
+			}
+
+			var added []utils.File
+
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil {
+					color.Red("[ FAILED ] %s: %v", path, err)
+					failed++
+					continue
+				}
+
+				if info.IsDir() {
+					if newName != "" {
+						return fmt.Errorf("--name can't be used with a directory, its files keep their own names")
+					}
+
+					if !recursive {
+						color.Red("[ FAILED ] %s: is a directory, use -r to add it with everything in it", path)
+						failed++
+						continue
+					}
 
-		path = strings.ReplaceAll(path, "\\ ", " ")
-		path = strings.ReplaceAll(path, "\\[", "[")
-		path = strings.ReplaceAll(path, "\\]", "]")
-		path = strings.ReplaceAll(path, "\\(", "(")
-		path = strings.ReplaceAll(path, "\\)", ")")
-		path = strings.ReplaceAll(path, "\\&", "&")
+					if err := addDirectory(path, dumpPath, deleteOriginal); err != nil {
+						color.Red("[ FAILED ] %s: %v", path, err)
+						failed++
+						continue
+					}
+					dirsAdded++
+					continue
+				}
 
-		path = utils.CleanPath(path)
+				// Absolute, so adding the same file again from somewhere else
+				// is still recognized as a new version of it.
+				if abs, err := filepath.Abs(path); err == nil {
+					path = abs
+				}
 
-		dir := filepath.Dir(path)
-		targetName := filepath.Base(path)
+				err, data := utils.EncryptFile(path, dumpPath, newName, vaultKey)
+				if err != nil {
+					color.Red("[ FAILED ] %s: %v", path, err)
+					failed++
+					continue
+				}
 
-		entries, err := os.ReadDir(dir)
+				added = append(added, data)
+			}
+
+			var results []utils.AddResult
+
+			if len(added) > 0 {
+				results, err = utils.AppendFiles(added, vaultKey, !separate)
+				if err != nil {
+					// Nothing points at the new blobs, don't leave them lying around.
+					for _, data := range added {
+						os.Remove(filepath.Join(dumpPath, data.Id+".enc"))
+					}
+					return fmt.Errorf("something went wrong while handling vault update: %w", err)
+				}
+			}
+
+			for i, result := range results {
+				data := added[i]
+				printAddResult(result, data.OriginalPath)
+
+				if deleteOriginal {
+					os.Remove(data.OriginalPath)
+					color.Red(fmt.Sprintf("[ DELETED ] file '%s' from disk (stored in vault)", data.OriginalName))
+				}
+			}
+
+			if len(args) > 1 || failed > 0 {
+				fmt.Printf("Added %d, failed %d\n", len(added)+dirsAdded, failed)
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of the files could not be added", failed)
+			}
+
+			return nil
+		},
+	}
+
+	addCmd.Flags().BoolP("delete", "d", false, "Delete the original file after storing the encrypted version")
+	addCmd.Flags().StringP("name", "n", "", "Add your own custom name (instead of the program interpreting the original file name) for better organization")
+	addCmd.Flags().BoolP("recursive", "r", false, "Add a directory and everything in it as a collection")
+	addCmd.Flags().Bool("new", false, "Store a separate entry even if the file is already in the vault, instead of a new version of it")
+	addCmd.Flags().SetInterspersed(true)
+
+	return addCmd
+}
+
+// expandAddArg turns one argument into the paths it stands for. Inside the
+// repl there's no shell to do it for us, so quotes, backslash escapes, ~ and
+// glob patterns are handled here. A path that exists as written always wins
+// over treating it as a pattern.
+func expandAddArg(arg string) ([]string, error) {
+	path := arg
+
+	if strings.HasPrefix(path, "'") && strings.HasSuffix(path, "'") {
+		path = strings.TrimPrefix(path, "'")
+		path = strings.TrimSuffix(path, "'")
+	} else if strings.HasPrefix(path, "\"") && strings.HasSuffix(path, "\"") {
+		path = strings.TrimPrefix(path, "\"")
+		path = strings.TrimSuffix(path, "\"")
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(utils.GetAppPaths()["home"], path[1:])
+	}
+
+	unescaped := strings.ReplaceAll(path, "\\ ", " ")
+	unescaped = strings.ReplaceAll(unescaped, "\\[", "[")
+	unescaped = strings.ReplaceAll(unescaped, "\\]", "]")
+	unescaped = strings.ReplaceAll(unescaped, "\\(", "(")
+	unescaped = strings.ReplaceAll(unescaped, "\\)", ")")
+	unescaped = strings.ReplaceAll(unescaped, "\\&", "&")
+
+	if actualPath, ok := resolveAddPath(unescaped); ok {
+		return []string{actualPath}, nil
+	}
+
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := filepath.Glob(path)
 		if err != nil {
-			fmt.Printf("Error reading directory: %v", err)
-			return
+			return nil, fmt.Errorf("invalid pattern: %w", err)
 		}
 
-		var actualPath string
-		for _, entry := range entries {
-			cleanedEntryName := utils.CleanPath(entry.Name())
-			if cleanedEntryName == targetName {
-				actualPath = filepath.Join(dir, entry.Name())
-				break
-			}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match")
 		}
 
-		if actualPath == "" {
-			fmt.Printf("File not found: %s", path)
-			return
+		return matches, nil
+	}
+
+	return nil, fmt.Errorf("file not found")
+}
+
+// resolveAddPath finds the file on disk whose name matches path once both are
+// cleaned of invisible characters, which pasted or dragged-in paths tend to
+// pick up.
+func resolveAddPath(path string) (string, bool) {
+	path = utils.CleanPath(path)
+
+	if _, err := os.Stat(path); err == nil {
+		return path, true
+	}
+
+	dir := filepath.Dir(path)
+	targetName := filepath.Base(path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for _, entry := range entries {
+		cleanedEntryName := utils.CleanPath(entry.Name())
+		if cleanedEntryName == targetName {
+			return filepath.Join(dir, entry.Name()), true
 		}
+	}
 
-		filePaths := utils.GetAppPaths()
-		dumpPath := filepath.Join(filePaths["userData"], "dump")
+	return "", false
+}
 
-		err, data := utils.EncryptFile(actualPath, dumpPath, newName, string(authenticatedPassword))
+// addStdin stores whatever is piped in as a file called name, without the
+// plaintext ever touching the disk.
+func addStdin(dumpPath string, name string, asVersion bool) error {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("stdin is a terminal, pipe the contents in instead, e.g. 'pg_dump | hideaway add - -n db-backup'")
+	}
 
-		if err != nil {
-			fmt.Printf("Something went wrong while encrypting file: %v", err)
-			return
+	data, err := utils.EncryptReader(os.Stdin, dumpPath, name, vaultKey)
+	if err != nil {
+		return fmt.Errorf("something went wrong while encrypting stdin: %w", err)
+	}
+
+	results, err := utils.AppendFiles([]utils.File{data}, vaultKey, asVersion)
+	if err != nil {
+		os.Remove(filepath.Join(dumpPath, data.Id+".enc"))
+		return fmt.Errorf("something went wrong while handling vault update: %w", err)
+	}
+
+	printAddResult(results[0], fmt.Sprintf("%s (%s from stdin)", name, utils.FormatSize(data.Size)))
+	fmt.Printf("File id: %s\n", results[0].File.Id)
+
+	return nil
+}
+
+func printAddResult(result utils.AddResult, label string) {
+	switch result.Status {
+	case utils.AddedDuplicate:
+		color.Cyan("[ ADDED ] %s (same contents as a file already in the vault, stored once)", label)
+	case utils.AddedVersion:
+		color.Cyan("[ UPDATED ] %s (version %d)", label, result.File.CurrentVersion())
+	case utils.Unchanged:
+		color.Cyan("[ UNCHANGED ] %s", label)
+	default:
+		color.Cyan("[ ADDED ] %s", label)
+	}
+}
+
+func addDirectory(dir string, dumpPath string, deleteOriginal bool) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	collection, files, err := utils.EncryptDirectory(absDir, dumpPath, vaultKey)
+	if err != nil {
+		return fmt.Errorf("something went wrong while encrypting directory: %w", err)
+	}
+
+	if err := utils.AddCollection(collection, files, vaultKey); err != nil {
+		return fmt.Errorf("something went wrong while handling vault update: %w", err)
+	}
+
+	color.Cyan("Successfully added %d files from '%s' to vault", len(files), collection.Name)
+	fmt.Printf("Collection id: %s\n", collection.Id)
+
+	if deleteOriginal {
+		for _, file := range files {
+			os.Remove(file.OriginalPath)
 		}
 
-		if deleteOriginal {
-			os.Remove(actualPath)
-			color.Red(fmt.Sprintf("[ DELETED ] file '%s' from disk (stored in vault)", data.OriginalName))
+		// Deepest first, so parents are empty by the time we get to them.
+		for i := len(collection.Dirs) - 1; i >= 0; i-- {
+			os.Remove(filepath.Join(absDir, filepath.FromSlash(collection.Dirs[i])))
 		}
+		os.Remove(absDir)
 
-		err = utils.AppendFile(data, authenticatedPassword)
+		color.Red(fmt.Sprintf("[ DELETED ] directory '%s' from disk (stored in vault)", collection.Name))
+	}
 
-		if err != nil {
-			color.Yellow("Something went wrong while handling vault update")
-			return
-		}
-	},
+	return nil
 }
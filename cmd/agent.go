@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep your vault unlocked in the background",
+	Long: `Run an agent that holds the unlocked vault key in memory, so commands don't ask for the password every time.
+The key is forgotten after the idle timeout or when you run 'hideaway lock'. Run it in the background with 'hideaway agent &'.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if !isInitialized() {
+			return errors.New("hideaway has not been initialized yet, run 'hideaway init' first")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		fmt.Fprintf(os.Stderr, "Hideaway agent listening on %s (locks after %s idle)\n", utils.AgentSocketPath(), timeout)
+
+		if err := utils.RunAgent(ctx, timeout); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Make the agent forget your vault key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := utils.AgentLock()
+		vaultKey, keyFromAgent = nil, false
+
+		if errors.Is(err, utils.ErrNoAgent) {
+			color.Yellow("No agent running, nothing to lock")
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		color.Cyan("Vault locked")
+		return nil
+	},
+}
+
+func init() {
+	agentCmd.Flags().Duration("timeout", 15*time.Minute, "Forget the key after this long without use (0 keeps it until 'hideaway lock')")
+}
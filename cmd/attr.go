@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newAttrCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "attr <id|name> [key=value...]",
+		Short: "Show or change the attributes of a file in your vault",
+		Long: `Attributes are your own key/value pairs on a file, e.g. 'attr invoice.pdf year=2024 client=acme'.
+Without pairs, print them. 'key=' removes an attribute.`,
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var file utils.File
+			var err error
+
+			if len(args) == 1 {
+				file, err = utils.FindFile(args[0], vaultKey)
+				if err != nil {
+					return fmt.Errorf("could not find file: %w", err)
+				}
+			} else {
+				attrs, err := parseAttributes(args[1:])
+				if err != nil {
+					return err
+				}
+
+				file, err = utils.SetAttributes(args[0], attrs, vaultKey)
+				if err != nil {
+					return fmt.Errorf("could not change attributes: %w", err)
+				}
+			}
+
+			if len(file.Attributes) == 0 {
+				color.HiBlack("%s has no attributes", file.OriginalName)
+				return nil
+			}
+
+			for _, key := range slices.Sorted(maps.Keys(file.Attributes)) {
+				fmt.Printf("%s=%s\n", key, file.Attributes[key])
+			}
+
+			return nil
+		},
+	}
+}
+
+// parseAttributes turns key=value arguments into a map.
+func parseAttributes(args []string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", arg)
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newCatCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cat <id|name>",
+		Short: "Write a file from your vault to stdout",
+		Long: `Decrypt a file straight to stdout, so it can be piped into another program without
+the plaintext ever touching the disk, e.g. 'hideaway cat db-backup | psql'.`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// stdout carries the file, anything else we print goes to stderr.
+			color.Output = color.Error
+
+			if err := requireUnlocked(cmd, args); err != nil {
+				restoreOutput()
+				return err
+			}
+
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer restoreOutput()
+
+			out := bufio.NewWriterSize(os.Stdout, utils.StreamChunkSize)
+
+			if err := utils.StreamFile(args[0], vaultKey, out); err != nil {
+				out.Flush()
+				return fmt.Errorf("could not decrypt file: %w", err)
+			}
+
+			return out.Flush()
+		},
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show your Hideaway settings",
+		Long:  "Show every setting with its current value, change them with 'config set <key> <value>'",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !isInitialized() {
+				return errors.New("hideaway has not been initialized yet, run 'hideaway init' first")
+			}
+
+			c, err := utils.ReadConfig()
+			if err != nil {
+				return fmt.Errorf("reading config: %w", err)
+			}
+
+			width := 0
+			for _, setting := range utils.Settings {
+				width = max(width, len(setting.Key))
+			}
+
+			for _, setting := range utils.Settings {
+				value := setting.Get(c)
+				if value == "" {
+					value = "(default)"
+				}
+
+				fmt.Printf("%-*s  %s\n", width, setting.Key, value)
+				color.HiBlack("%-*s  %s", width, "", setting.Description)
+			}
+
+			return nil
+		},
+	}
+
+	configCmd.AddCommand(&cobra.Command{
+		Use:     "set <key> [value]",
+		Short:   "Change a setting, leave out the value to restore its default",
+		Args:    cobra.RangeArgs(1, 2),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value := ""
+			if len(args) == 2 {
+				value = args[1]
+			}
+
+			if err := utils.SetSetting(args[0], value); err != nil {
+				return fmt.Errorf("could not change %s: %w", args[0], err)
+			}
+
+			color.Cyan("Updated %s", args[0])
+
+			if strings.HasPrefix(args[0], "keep-versions") {
+				pruned, err := utils.PruneVersions(vaultKey)
+				if err != nil {
+					return fmt.Errorf("could not prune old versions: %w", err)
+				}
+				if pruned > 0 {
+					color.Cyan("Pruned %d old versions", pruned)
+				}
+			}
+
+			return nil
+		},
+	})
+
+	return configCmd
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newEditCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "edit <id|name>",
+		Short: "Edit a file from your vault in your editor",
+		Long: `Decrypt a file to a private temporary directory, open it in $VISUAL or $EDITOR and
+store the result under the same id once the editor exits. The temporary copy is
+overwritten and removed afterwards.`,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := utils.FindFile(args[0], vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not find file: %w", err)
+			}
+
+			dir, err := utils.PrivateTempDir()
+			if err != nil {
+				return fmt.Errorf("refusing to decrypt: %w", err)
+			}
+
+			// Base, so a stored name can't point outside of the directory.
+			path := filepath.Join(dir, filepath.Base(file.OriginalName))
+
+			if _, err := utils.RetrieveFile(file.Id, vaultKey, utils.RetrieveOptions{Output: path}); err != nil {
+				utils.RemoveSecurely(dir)
+				return fmt.Errorf("could not decrypt file: %w", err)
+			}
+
+			if err := runEditor(path); err != nil {
+				utils.RemoveSecurely(dir)
+				return fmt.Errorf("editor failed, nothing was changed: %w", err)
+			}
+
+			changed, err := utils.ReplaceFile(file.Id, path, vaultKey)
+			if err != nil {
+				color.Yellow("Your edited copy is still at %s and is NOT encrypted, add it again and remove it yourself", path)
+				return fmt.Errorf("could not store the edited file: %w", err)
+			}
+
+			if err := utils.RemoveSecurely(dir); err != nil {
+				color.Yellow("Could not remove the temporary copy in %s: %v", dir, err)
+			}
+
+			if !changed {
+				fmt.Println("No changes")
+				return nil
+			}
+
+			color.Cyan("Saved %s", file.OriginalName)
+			return nil
+		},
+	}
+}
+
+// runEditor opens path in the user's editor and waits for it to exit.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+
+	var c *exec.Cmd
+	switch {
+	case runtime.GOOS == "windows":
+		if editor == "" {
+			editor = "notepad"
+		}
+		c = exec.Command("cmd", "/C", editor, path)
+	default:
+		if editor == "" {
+			editor = "vi"
+		}
+		// Through the shell, so editors with arguments like "code --wait" work.
+		c = exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	}
+
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	// Ctrl+C belongs to the editor, it mustn't kill us and leave the
+	// plaintext behind.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	return c.Run()
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newFsckCmd() *cobra.Command {
+	fsckCmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check your vault for missing, orphaned and corrupt files",
+		Long: `Decrypt every file in your vault to verify it hasn't been damaged or tampered with,
+and check that the database and the encrypted files on disk agree with each other.`,
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repair, _ := cmd.Flags().GetBool("repair")
+
+			report, err := utils.CheckVault(vaultKey, repair)
+			if err != nil {
+				return fmt.Errorf("could not check vault: %w", err)
+			}
+
+			unfixed := 0
+			for _, issue := range report.Issues {
+				label := issue.FileId
+				if issue.Name != "" {
+					label = issue.Name
+				}
+
+				line := fmt.Sprintf("[ %s ] %s: %s", issue.Kind, label, issue.Detail)
+
+				if issue.Fixed != "" {
+					color.Yellow("%s (%s)", line, issue.Fixed)
+				} else {
+					unfixed++
+					color.Red(line)
+				}
+			}
+
+			fmt.Printf("Checked %d files, found %d problems\n", report.Checked, len(report.Issues))
+
+			if unfixed > 0 {
+				if !repair {
+					fmt.Println("Run 'fsck --repair' to fix them")
+				}
+				return fmt.Errorf("%d problems left", unfixed)
+			}
+
+			return nil
+		},
+	}
+
+	fsckCmd.Flags().Bool("repair", false, "Drop records without a file, quarantine corrupt files and re-register orphans")
+
+	return fsckCmd
+}
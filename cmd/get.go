@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newGetCmd() *cobra.Command {
+	getCmd := &cobra.Command{
+		Use:   "get <id|name>",
+		Short: "Retrieve a file from your vault",
+		Long: `Decrypt a file or a whole collection from your vault. By default it goes back to where
+it was added from, or to the retrieve-dir setting if that place is gone. Use -o to pick
+the exact path, or --output-dir to pick the directory it goes into.`,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, _ := cmd.Flags().GetString("output")
+			outputDir, _ := cmd.Flags().GetString("output-dir")
+			onConflict, _ := cmd.Flags().GetString("on-conflict")
+
+			if output != "" && outputDir != "" {
+				return errors.New("use either --output or --output-dir, not both")
+			}
+
+			opts := utils.RetrieveOptions{Output: output, OutputDir: outputDir}
+
+			if onConflict != "" {
+				policy, err := utils.ParseConflictPolicy(onConflict)
+				if err != nil {
+					return err
+				}
+				opts.OnConflict = policy
+			}
+
+			root, err := utils.RetrieveCollection(args[0], vaultKey, opts)
+			if err == nil {
+				color.Cyan("Restored collection to %s", root)
+				return nil
+			}
+
+			if errors.Is(err, utils.ErrSkipped) {
+				color.Yellow("%v", err)
+				return nil
+			}
+
+			if !errors.Is(err, utils.ErrNotFound) {
+				return fmt.Errorf("could not retrieve collection: %w", err)
+			}
+
+			target, err := utils.RetrieveFile(args[0], vaultKey, opts)
+			if errors.Is(err, utils.ErrSkipped) {
+				color.Yellow("%v", err)
+				return nil
+			}
+
+			if err != nil {
+				return fmt.Errorf("could not retrieve file: %w", err)
+			}
+
+			color.Cyan("Decrypted to %s", target)
+			return nil
+		},
+	}
+
+	getCmd.Flags().StringP("output", "o", "", "Path to write the file to (an existing directory means into it)")
+	getCmd.Flags().String("output-dir", "", "Directory to write the file into, under its original name")
+	getCmd.Flags().String("on-conflict", "", "What to do when the target already exists: skip, overwrite or rename (default from the on-conflict setting, or rename)")
+
+	return getCmd
+}
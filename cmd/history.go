@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <id|name>",
+		Short: "List the stored versions of a file",
+		Long: `List every version of a file that is still kept, newest first, with when it was stored,
+its size and the start of its SHA-256. Bring an old one back with 'restore <id>@<n>'.`,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := utils.FindFile(args[0], vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not find file: %w", err)
+			}
+
+			fmt.Printf("%s (%s)\n\n", file.OriginalName, file.Id)
+
+			color.Cyan("* %-4d %s  %10s  %s", file.CurrentVersion(), file.Modified().Format("2006-01-02 15:04:05"), utils.FormatSize(file.Size), shortHash(file.SHA256))
+
+			for i := len(file.Versions) - 1; i >= 0; i-- {
+				v := file.Versions[i]
+				fmt.Printf("  %-4d %s  %10s  %s\n", v.Number, v.DateAdded.Format("2006-01-02 15:04:05"), utils.FormatSize(v.Size), shortHash(v.SHA256))
+			}
+
+			return nil
+		},
+	}
+}
+
+func shortHash(sum string) string {
+	if sum == "" {
+		return "-"
+	}
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -113,29 +112,18 @@ var initCmd = &cobra.Command{
 			return
 		}
 
-		salt, err := utils.GenerateSalt(32)
-		if err != nil {
-			fmt.Println("Something went wrong while generating salt rounds")
-			return
-		}
-
 		passwordInBytes := []byte(m.inputs[0].Value())
-		hashed, err := utils.Hash(passwordInBytes, salt)
+		config, err := utils.NewVaultConfig(passwordInBytes)
 		if err != nil {
-			fmt.Println("Something went wrong while hashing the password")
+			fmt.Println("Something went wrong while setting up the master password")
 			return
 		}
 
-		config := utils.Config{
-			Salt:           salt,
-			HashedPassword: hashed,
-		}
-
 		if err := createRootFolder(); err != nil {
 			fmt.Println("Something went wrong while creating root folder")
 			return
 		}
-		if err := createConfig(config); err != nil {
+		if err := utils.WriteConfig(config); err != nil {
 			fmt.Println("Something went wrong while creating config file")
 			return
 		}
@@ -146,23 +134,6 @@ var initCmd = &cobra.Command{
 	},
 }
 
-func createConfig(data utils.Config) error {
-	filePaths := utils.GetAppPaths()
-	configFilePath := filepath.Join(filePaths["userData"], "config.json")
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	err = os.WriteFile(configFilePath, jsonData, 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func createRootFolder() error {
 	filePaths := utils.GetAppPaths()
 	rootPath := filePaths["userData"]
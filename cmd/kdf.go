@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+var kdfCmd = &cobra.Command{
+	Use:   "kdf",
+	Short: "Show the key derivation settings of your vault",
+	Long:  "Show which key derivation function protects your vault key and with which parameters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !isInitialized() {
+			return errors.New("hideaway has not been initialized yet, run 'hideaway init' first")
+		}
+
+		cmd.SilenceUsage = true
+
+		c, err := utils.ReadConfig()
+		if err != nil {
+			return fmt.Errorf("reading config: %w", err)
+		}
+
+		fmt.Println(describeKDF(c.KeyDerivation()))
+
+		if c.KeyDerivation().Algorithm != utils.KDFArgon2id || c.LegacyVaultKey {
+			color.Yellow("Run 'hideaway kdf upgrade' to switch to Argon2id")
+		}
+
+		return nil
+	},
+}
+
+var kdfUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Re-key your vault with Argon2id tuned for this machine",
+	Long: `Benchmark Argon2id on this machine, then re-wrap the vault key with the new parameters.
+Vaults created before the vault key was random are re-encrypted with a fresh key.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, _ := cmd.Flags().GetDuration("target")
+		memoryMiB, _ := cmd.Flags().GetUint32("memory")
+
+		// In uint64, so a huge value can't wrap around to a small one.
+		if uint64(memoryMiB)*1024 > utils.MaxArgon2Memory {
+			return fmt.Errorf("--memory must be at most %d MiB, got %d", utils.MaxArgon2Memory/1024, memoryMiB)
+		}
+
+		if !isInitialized() {
+			return errors.New("hideaway has not been initialized yet, run 'hideaway init' first")
+		}
+
+		// Anything failing from here on isn't a usage mistake.
+		cmd.SilenceUsage = true
+
+		password, err := readMasterPassword(cmd)
+		if err != nil {
+			return fmt.Errorf("error reading password: %w", err)
+		}
+
+		key, err := utils.UnlockVault(password)
+		if err != nil {
+			return fmt.Errorf("error unlocking vault: %w", err)
+		}
+
+		fmt.Printf("Benchmarking Argon2id with %d MiB of memory...\n", memoryMiB)
+
+		params, took, err := utils.BenchmarkArgon2(target, memoryMiB*1024)
+		if err != nil {
+			return fmt.Errorf("benchmark failed: %w", err)
+		}
+
+		fmt.Printf("%s (%s per unlock)\n", describeKDF(params), took.Round(time.Millisecond))
+
+		if err := utils.UpgradeKDF(password, key, params); err != nil {
+			return fmt.Errorf("could not upgrade vault: %w", err)
+		}
+
+		// The vault key may have changed, don't let the agent hand out the old one.
+		utils.AgentLock()
+
+		color.Cyan("Vault re-keyed with the new parameters")
+		return nil
+	},
+}
+
+func init() {
+	kdfUpgradeCmd.Flags().Duration("target", time.Second, "How long a single unlock should take on this machine")
+	kdfUpgradeCmd.Flags().Uint32("memory", 64, "Memory cost in MiB")
+
+	kdfCmd.AddCommand(kdfUpgradeCmd)
+}
+
+func describeKDF(params utils.KDFParams) string {
+	switch params.Algorithm {
+	case utils.KDFArgon2id:
+		return fmt.Sprintf("Argon2id: time=%d memory=%dMiB threads=%d", params.Time, params.Memory/1024, params.Threads)
+	case utils.KDFPBKDF2:
+		return fmt.Sprintf("PBKDF2-SHA256: iterations=%d", params.Time)
+	default:
+		return fmt.Sprintf("unknown KDF %d", params.Algorithm)
+	}
+}
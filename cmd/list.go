@@ -2,15 +2,15 @@ package cmd
 
 import (
 	"fmt"
-	"log"
+	"os"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	utils "github.com/sklyerx/hideaway/utils"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
@@ -48,8 +48,10 @@ var (
 )
 
 type tableModel struct {
-	data     []map[string]interface{}
-	headers  []string
+	rows     []utils.File
+	columns  []utils.Column
+	order    utils.SortOrder
+	sorted   bool
 	cursor   int
 	viewport struct {
 		start int
@@ -59,24 +61,27 @@ type tableModel struct {
 	height      int
 	message     string
 	isConfirmed bool
+
+	// files are the ones the command line asked for, keep is reapplied when
+	// they are reloaded after a delete. search narrows them down further.
+	files     []utils.File
+	keep      func(utils.File) bool
+	search    string
+	searching bool
+	searchErr string
 }
 
 type clearMessageMsg struct{}
 
-func newTableModel(jsonData []map[string]interface{}) tableModel {
+func newTableModel(files []utils.File, columns []utils.Column, order utils.SortOrder) tableModel {
 	m := tableModel{
-		data: jsonData,
-	}
-
-	// Extract headers from the first object
-	if len(jsonData) > 0 {
-		for key := range jsonData[0] {
-			m.headers = append(m.headers, key)
-		}
+		files:   files,
+		columns: columns,
+		order:   order,
+		keep:    func(utils.File) bool { return true },
 	}
 
-	m.viewport.start = 0
-	m.viewport.end = len(jsonData)
+	m.applySearch()
 
 	return m
 }
@@ -92,17 +97,32 @@ func (m tableModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		visibleRows := m.height - 6
 		if visibleRows > 0 {
-			m.viewport.end = min(len(m.data), visibleRows)
+			m.viewport.end = min(len(m.rows), visibleRows)
 		}
 		return m, nil
 
 	case tea.KeyMsg:
+		if m.searching {
+			return m.updateSearch(msg)
+		}
+
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return m, tea.Quit
 
+		case "/":
+			m.searching = true
+			m.isConfirmed = false
+			m.message = ""
+
+		case "esc":
+			if m.search != "" {
+				m.search = ""
+				m.applySearch()
+			}
+
 		case "j", "down":
-			if m.cursor < len(m.data)-1 {
+			if m.cursor < len(m.rows)-1 {
 				m.cursor++
 				// Auto-scroll viewport
 				if m.cursor >= m.viewport.end {
@@ -124,91 +144,80 @@ func (m tableModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "g":
 			m.cursor = 0
 			m.viewport.start = 0
-			m.viewport.end = min(len(m.data), m.height-6)
+			m.viewport.end = min(len(m.rows), m.height-6)
 
 		case "G":
-			m.cursor = len(m.data) - 1
-			if len(m.data) > m.height-6 {
-				m.viewport.start = len(m.data) - (m.height - 6)
-				m.viewport.end = len(m.data)
+			m.cursor = len(m.rows) - 1
+			if len(m.rows) > m.height-6 {
+				m.viewport.start = len(m.rows) - (m.height - 6)
+				m.viewport.end = len(m.rows)
+			}
+		case "s", "S":
+			if msg.String() == "s" {
+				m.order = m.order.Next()
+			} else {
+				m.order.Desc = !m.order.Desc
 			}
-		case "r":
-			index := m.cursor
-			dataFiles := m.data
 
-			obj := dataFiles[index]
+			m.applySearch()
 
-			id, ok := obj["Id"]
-			if ok {
-				if idStr, ok := id.(string); ok {
-					utils.RetrieveFile(idStr, authenticatedPassword)
-				} else {
-					color.Red("Invalid ID type, expected string")
-				}
+			// Saved by showTable once the table closes, saving here would
+			// hold up the UI while it waits for the vault lock.
+			m.sorted = true
+			m.message = fmt.Sprintf("Sorted by %s", m.order)
+			return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+				return clearMessageMsg{}
+			})
+
+		case "r":
+			if len(m.rows) == 0 {
+				return m, nil
+			}
+
+			target, err := utils.RetrieveFile(m.rows[m.cursor].Id, vaultKey, utils.RetrieveOptions{})
+			if err != nil {
+				m.message = fmt.Sprintf("Error retrieving file: %v", err)
+			} else {
+				m.message = fmt.Sprintf("Decrypted to %s", target)
 			}
+			return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+				return clearMessageMsg{}
+			})
 
 		case "d":
 			m.message = "Press 'y' to confirm delete, 'n' to cancel"
 			m.isConfirmed = true
 
 		case "y":
-			if !m.isConfirmed {
+			if !m.isConfirmed || len(m.rows) == 0 {
 				return m, nil
 			}
 
-			index := m.cursor
-			obj := m.data[index]
-			id, ok := obj["Id"]
-
-			if ok {
-				updatedFiles, err := utils.DeleteFile(id.(string), authenticatedPassword)
-
-				if err != nil {
-					m.message = "Error deleting file"
-					m.isConfirmed = false
-					return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
-						return clearMessageMsg{}
-					})
-				}
-
-				files := []map[string]interface{}{}
-				for _, file := range updatedFiles {
-					fileMap := map[string]interface{}{
-						"Id":            file.Id,
-						"Original Name": file.OriginalName,
-						"Date Added":    file.DateAdded.Format("2006-01-02 15:04:05"),
-						"Mime Type":     file.MimeType,
-						"Extension":     file.Extension,
-					}
-					files = append(files, fileMap)
-				}
-
-				m.data = files
-
-				if m.cursor >= len(m.data) && len(m.data) > 0 {
-					m.cursor = len(m.data) - 1
-				}
+			updatedFiles, err := utils.DeleteFile(m.rows[m.cursor].Id, vaultKey)
 
-				visibleRows := m.height - 6
-				if visibleRows > 0 {
-					m.viewport.start = 0
-					m.viewport.end = min(len(m.data), visibleRows)
-				}
-
-				m.message = "File deleted successfully!"
-				m.isConfirmed = false
-
-				return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
-					return clearMessageMsg{}
-				})
-			} else {
-				m.message = "Invalid file ID"
+			if err != nil {
+				m.message = "Error deleting file"
 				m.isConfirmed = false
 				return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
 					return clearMessageMsg{}
 				})
 			}
 
+			m.files = nil
+			for _, file := range updatedFiles {
+				if m.keep(file) {
+					m.files = append(m.files, file)
+				}
+			}
+			m.applySearch()
+
+			m.message = "File deleted successfully!"
+			m.isConfirmed = false
+
+			return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+				return clearMessageMsg{}
+			})
+
 		case "n":
 			if m.isConfirmed {
 				m.message = "Delete cancelled"
@@ -226,19 +235,110 @@ func (m tableModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	if m.viewport.end == 0 && m.height == 0 {
-		m.viewport.end = min(len(m.data), 10)
+		m.viewport.end = min(len(m.rows), 10)
 	} else if m.height > 0 {
 		visibleRows := m.height - 6
 		if visibleRows > 0 && m.viewport.end == 0 {
-			m.viewport.end = min(len(m.data), visibleRows)
+			m.viewport.end = min(len(m.rows), visibleRows)
 		}
 	}
 
 	return m, nil
 }
 
+// updateSearch handles keys while the search query is being typed, the rows
+// are narrowed down as it changes.
+func (m tableModel) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+
+	case tea.KeyEnter:
+		m.searching = false
+		return m, nil
+
+	case tea.KeyEsc:
+		m.searching = false
+		m.search = ""
+
+	case tea.KeyBackspace:
+		if m.search == "" {
+			m.searching = false
+			return m, nil
+		}
+		runes := []rune(m.search)
+		m.search = string(runes[:len(runes)-1])
+
+	case tea.KeySpace:
+		m.search += " "
+
+	case tea.KeyRunes:
+		m.search += string(msg.Runes)
+
+	default:
+		return m, nil
+	}
+
+	m.applySearch()
+	return m, nil
+}
+
+// applySearch rebuilds the rows from the files that match the search, in the
+// current order, and keeps the cursor on the same file when it's still there.
+// While the query doesn't parse the rows stay as they were.
+func (m *tableModel) applySearch() {
+	query, err := utils.ParseQuery(m.search)
+	if err != nil {
+		m.searchErr = err.Error()
+		return
+	}
+
+	selected := ""
+	if m.cursor < len(m.rows) {
+		selected = m.rows[m.cursor].Id
+	}
+
+	m.searchErr = ""
+	m.rows = query.Filter(m.files)
+	utils.SortFiles(m.rows, m.order)
+
+	m.cursor = min(m.cursor, len(m.rows)-1)
+	for i, file := range m.rows {
+		if file.Id == selected {
+			m.cursor = i
+		}
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+
+	m.viewport.start = 0
+	m.viewport.end = len(m.rows)
+	if visibleRows := m.height - 6; visibleRows > 0 {
+		m.viewport.end = min(len(m.rows), visibleRows)
+	}
+	if m.cursor >= m.viewport.end {
+		m.viewport.start = m.cursor - (m.viewport.end - m.viewport.start) + 1
+		m.viewport.end = m.cursor + 1
+	}
+}
+
+func (m tableModel) searchBar() string {
+	bar := "/" + m.search
+	if m.searching {
+		bar += "█"
+	}
+	if m.searchErr != "" {
+		bar += "  (" + m.searchErr + ")"
+	}
+	return helpStyle.Render(bar)
+}
+
 func (m tableModel) View() string {
-	if len(m.data) == 0 {
+	if len(m.rows) == 0 {
+		if m.search != "" || m.searching {
+			return lipgloss.JoinVertical(lipgloss.Left, "No files match", m.searchBar())
+		}
 		return "No data to display!"
 	}
 
@@ -247,20 +347,29 @@ func (m tableModel) View() string {
 		availableWidth = 40
 	}
 
-	colWidths := make(map[string]int)
+	titles := make([]string, len(m.columns))
+	for i, column := range m.columns {
+		titles[i] = column.Title
+		if column.Key == m.order.Column() {
+			if m.order.Desc {
+				titles[i] += " ↓"
+			} else {
+				titles[i] += " ↑"
+			}
+		}
+	}
+
+	colWidths := make([]int, len(m.columns))
 
-	minWidths := make(map[string]int)
-	for _, header := range m.headers {
-		minWidths[header] = len(header)
+	minWidths := make([]int, len(m.columns))
+	for i, title := range titles {
+		minWidths[i] = utf8.RuneCountInString(title)
 	}
 
-	for _, row := range m.data {
-		for _, header := range m.headers {
-			if val, exists := row[header]; exists {
-				strVal := fmt.Sprintf("%v", val)
-				if len(strVal) > minWidths[header] {
-					minWidths[header] = len(strVal)
-				}
+	for _, row := range m.rows {
+		for i, column := range m.columns {
+			if width := utf8.RuneCountInString(column.Value(row)); width > minWidths[i] {
+				minWidths[i] = width
 			}
 		}
 	}
@@ -270,32 +379,25 @@ func (m tableModel) View() string {
 		totalMinWidth += width
 	}
 
-	if totalMinWidth > 0 {
-		for _, header := range m.headers {
-			proportion := float64(minWidths[header]) / float64(totalMinWidth)
-			calculatedWidth := int(float64(availableWidth) * proportion)
-
-			if calculatedWidth < 8 {
-				calculatedWidth = 8
-			}
-			if calculatedWidth > 50 {
-				calculatedWidth = 50
-			}
+	for i := range m.columns {
+		proportion := float64(minWidths[i]) / float64(totalMinWidth)
+		calculatedWidth := int(float64(availableWidth) * proportion)
 
-			colWidths[header] = calculatedWidth
+		if calculatedWidth < 8 {
+			calculatedWidth = 8
 		}
-	} else {
-		equalWidth := availableWidth / len(m.headers)
-		for _, header := range m.headers {
-			colWidths[header] = equalWidth
+		if calculatedWidth > 50 {
+			calculatedWidth = 50
 		}
+
+		colWidths[i] = calculatedWidth
 	}
 
 	var b strings.Builder
 
 	var headerCells []string
-	for _, header := range m.headers {
-		cell := headerStyle.Width(colWidths[header]).Render(truncateString(header, colWidths[header]))
+	for i, title := range titles {
+		cell := headerStyle.Width(colWidths[i]).Render(truncateString(title, colWidths[i]))
 		headerCells = append(headerCells, cell)
 	}
 	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, headerCells...))
@@ -303,19 +405,16 @@ func (m tableModel) View() string {
 
 	visibleStart := m.viewport.start
 	visibleEnd := m.viewport.end
-	if visibleEnd > len(m.data) {
-		visibleEnd = len(m.data)
+	if visibleEnd > len(m.rows) {
+		visibleEnd = len(m.rows)
 	}
 
 	for i := visibleStart; i < visibleEnd; i++ {
-		row := m.data[i]
+		row := m.rows[i]
 		var rowCells []string
 
-		for _, header := range m.headers {
-			val := ""
-			if v, exists := row[header]; exists {
-				val = fmt.Sprintf("%v", v)
-			}
+		for j, column := range m.columns {
+			val := column.Value(row)
 
 			cellStyle := normalRowStyle
 			if i%2 == 1 {
@@ -325,7 +424,7 @@ func (m tableModel) View() string {
 				cellStyle = selectedRowStyle
 			}
 
-			cell := cellStyle.Width(colWidths[header]).Render(truncateString(val, colWidths[header]))
+			cell := cellStyle.Width(colWidths[j]).Render(truncateString(val, colWidths[j]))
 			rowCells = append(rowCells, cell)
 		}
 
@@ -333,12 +432,12 @@ func (m tableModel) View() string {
 		b.WriteString("\n")
 	}
 
-	const helpBar = "j/k: up/down • g/G: top/bottom • q: quit • r: retrieve • d: delete"
+	const helpBar = "j/k: up/down • g/G: top/bottom • /: search • s/S: sort/reverse • q: quit • r: retrieve • d: delete"
 
 	table := tableStyle.Render(b.String())
 	table = tableStyle.Width(m.width - 2).Render(b.String())
 	help := helpStyle.Render(helpBar)
-	status := helpStyle.Render(fmt.Sprintf("Row %d of %d", m.cursor+1, len(m.data)))
+	status := helpStyle.Render(fmt.Sprintf("Row %d of %d", m.cursor+1, len(m.rows)))
 
 	if m.message != "" {
 		help = helpStyle.Render(m.message)
@@ -346,6 +445,10 @@ func (m tableModel) View() string {
 		help = helpStyle.Render(helpBar)
 	}
 
+	if m.searching || (m.search != "" && m.message == "") {
+		help = m.searchBar()
+	}
+
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
 		table,
@@ -354,13 +457,14 @@ func (m tableModel) View() string {
 }
 
 func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
 		return s
 	}
 	if maxLen <= 3 {
-		return s[:maxLen]
+		return string(runes[:maxLen])
 	}
-	return s[:maxLen-3] + "..."
+	return string(runes[:maxLen-3]) + "..."
 }
 
 func min(a, b int) int {
@@ -370,47 +474,153 @@ func min(a, b int) int {
 	return b
 }
 
-func showTable(data []map[string]interface{}) error {
-	if len(data) == 0 {
+func showTable(files []utils.File, keep func(utils.File) bool, columns []utils.Column, order utils.SortOrder) error {
+	if len(files) == 0 {
 		fmt.Println("No files found in vault")
 		return nil
 	}
 
-	p := tea.NewProgram(newTableModel(data), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	m := newTableModel(files, columns, order)
+	m.keep = keep
+
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
 		return fmt.Errorf("failed to run table viewer: %w", err)
 	}
+
+	if m, ok := final.(tableModel); ok && m.sorted {
+		if err := utils.SetSetting("list-sort", m.order.String()); err != nil {
+			return fmt.Errorf("could not save the sort order: %w", err)
+		}
+	}
 	return nil
 }
 
-var listCmd = &cobra.Command{
-	Use:   "list",
-	Short: "Get a list of all the files in your vault",
-	Long:  "A table view of all the files that are in your encrypted vault",
-	Run: func(cmd *cobra.Command, args []string) {
+func newListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "Get a list of all the files in your vault",
+		Long: `A table view of all the files that are in your encrypted vault. --query narrows it down,
+e.g. 'list --query "ext:pdf tag:tax added:>2025-01-01 size:>10MB"', and so does pressing /
+in the table. Fields are name, ext, mime, tag, attr, note, added, modified and size, a bare
+word matches the name and a leading - negates a term.
+
+Press s in the table to sort by the next of name, date, size and type, and S to reverse
+the order, the choice is remembered. Pick the columns with 'config set list-columns'.
+
+--output json, csv or tsv prints every matching file for scripts, with the fields listed
+in the README, and --output table a plain table. When stdout isn't a terminal, e.g. in a
+pipe, the plain table is printed unless another format is asked for.`,
+		PreRunE: requireUnlockedForOutput,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer restoreOutput()
+
+			data, err := utils.GetVaultContent(vaultKey)
+
+			if err != nil {
+				return fmt.Errorf("could not get vault content: %w", err)
+			}
+
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			attrs, _ := cmd.Flags().GetStringArray("attr")
+			note, _ := cmd.Flags().GetString("note")
+			queryStr, _ := cmd.Flags().GetString("query")
+			columnsStr, _ := cmd.Flags().GetString("columns")
+			sortStr, _ := cmd.Flags().GetString("sort")
+			outputStr, _ := cmd.Flags().GetString("output")
+
+			// Without a terminal to draw on, the table is printed instead.
+			var format outputFormat
+			if outputStr != "" {
+				if format, err = parseOutputFormat(outputStr); err != nil {
+					return err
+				}
+			} else if !stdoutIsTerminal() {
+				format = outputTable
+			}
 
-		data, err := utils.GetVaultContent(authenticatedPassword)
+			query, err := utils.ParseQuery(queryStr)
+			if err != nil {
+				return fmt.Errorf("invalid query: %w", err)
+			}
 
-		if err != nil {
-			color.Yellow("Could not get vault content")
-			return
-		}
+			// A config that can't be read just means the default layout.
+			config, _ := utils.ReadConfig()
 
-		files := []map[string]interface{}{}
+			columns := config.ListColumns()
+			if columnsStr != "" {
+				if columns, err = utils.ParseColumns(columnsStr); err != nil {
+					return err
+				}
+			}
 
-		for _, file := range data {
-			fileMap := map[string]interface{}{
-				"Id":            file.Id,
-				"Original Name": file.OriginalName,
-				"Date Added":    file.DateAdded.Format("2006-01-02 15:04:05"),
-				"Mime Type":     file.MimeType,
-				"Extension":     file.Extension,
+			order := config.ListSortOrder()
+			if sortStr != "" {
+				if order, err = utils.ParseSortOrder(sortStr); err != nil {
+					return err
+				}
 			}
-			files = append(files, fileMap)
-		}
 
-		if err := showTable(files); err != nil {
-			log.Fatalf("Error displaying table: %v", err)
-		}
-	},
+			filter := utils.MetadataFilter{Tags: tags, Note: note}
+			if len(attrs) > 0 {
+				filter.Attributes = map[string]string{}
+				for _, attr := range attrs {
+					key, value, _ := strings.Cut(attr, "=")
+					filter.Attributes[key] = value
+				}
+			}
+
+			keep := func(file utils.File) bool {
+				return filter.Match(file) && query.Match(file)
+			}
+
+			var matched []utils.File
+			for _, file := range data {
+				if keep(file) {
+					matched = append(matched, file)
+				}
+			}
+
+			utils.SortFiles(matched, order)
+
+			switch format {
+			case outputJSON:
+				return utils.WriteJSON(os.Stdout, utils.NewFileRecords(matched))
+			case outputCSV, outputTSV:
+				return utils.WriteRecords(os.Stdout, utils.NewFileRecords(matched), format.separator())
+			}
+
+			if len(data) == 0 {
+				fmt.Println("No files found in vault")
+				return nil
+			}
+
+			if len(matched) == 0 {
+				fmt.Println("No files match")
+				return nil
+			}
+
+			if format == outputTable {
+				return printTable(matched, columns)
+			}
+
+			if err := showTable(matched, keep, columns, order); err != nil {
+				return fmt.Errorf("error displaying table: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	listCmd.Flags().StringSlice("tag", nil, "Only list files with this tag, repeat it or separate tags with commas to require several")
+	listCmd.Flags().StringArray("attr", nil, "Only list files with this attribute, as key=value or just key")
+	listCmd.Flags().String("note", "", "Only list files whose note contains this text")
+	listCmd.Flags().StringP("query", "q", "", "Only list files matching this query, e.g. 'ext:pdf tag:tax size:>10MB'")
+	listCmd.Flags().String("columns", "", "Comma separated columns to show this time, e.g. name,size,tags (default from the list-columns setting)")
+	listCmd.Flags().StringP("output", "o", "", "Print json, csv, tsv or a plain table instead of the interactive one (a plain table when not on a terminal)")
+	listCmd.Flags().String("sort", "", "Sort by name, date, size or type, add -desc to reverse (default from the list-sort setting)")
+
+	return listCmd
 }
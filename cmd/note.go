@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newNoteCmd() *cobra.Command {
+	noteCmd := &cobra.Command{
+		Use:   "note <id|name> [text...]",
+		Short: "Show or change the note on a file in your vault",
+		Long: `Without text, print the note on a file. With text, replace the note with it, the words
+are joined by spaces so quoting is optional. Use --clear to remove the note.`,
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clear, _ := cmd.Flags().GetBool("clear")
+
+			if len(args) == 1 && !clear {
+				file, err := utils.FindFile(args[0], vaultKey)
+				if err != nil {
+					return fmt.Errorf("could not find file: %w", err)
+				}
+
+				if file.Note == "" {
+					color.HiBlack("%s has no note", file.OriginalName)
+					return nil
+				}
+
+				fmt.Println(file.Note)
+				return nil
+			}
+
+			if clear && len(args) > 1 {
+				return fmt.Errorf("use either --clear or a note, not both")
+			}
+
+			file, err := utils.SetNote(args[0], strings.Join(args[1:], " "), vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not change note: %w", err)
+			}
+
+			if clear {
+				color.Cyan("Removed the note on %s", file.OriginalName)
+			} else {
+				color.Cyan("Saved the note on %s", file.OriginalName)
+			}
+			return nil
+		},
+	}
+
+	noteCmd.Flags().Bool("clear", false, "Remove the note")
+
+	return noteCmd
+}
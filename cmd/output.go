@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// outputFormat is what list and stats print, see utils/export.go for the
+// schema of the machine-readable ones.
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+	outputCSV   outputFormat = "csv"
+	outputTSV   outputFormat = "tsv"
+)
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch format := outputFormat(strings.ToLower(s)); format {
+	case outputTable, outputJSON, outputCSV, outputTSV:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unknown output format %q, use json, csv, tsv or table", s)
+}
+
+func (f outputFormat) separator() rune {
+	if f == outputTSV {
+		return '\t'
+	}
+	return ','
+}
+
+// stdout is where color prints normally, see requireUnlockedForOutput.
+var stdout = color.Output
+
+// requireUnlockedForOutput is requireUnlocked for commands with an --output
+// flag. With a machine-readable format anything else they print goes to
+// stderr, so warnings can't end up in the middle of the JSON. RunE has to
+// call restoreOutput.
+func requireUnlockedForOutput(cmd *cobra.Command, args []string) error {
+	if format, _ := cmd.Flags().GetString("output"); format != "" && outputFormat(strings.ToLower(format)) != outputTable {
+		color.Output = color.Error
+	}
+
+	if err := requireUnlocked(cmd, args); err != nil {
+		restoreOutput()
+		return err
+	}
+
+	return nil
+}
+
+func restoreOutput() {
+	color.Output = stdout
+}
+
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// flattenCell keeps tabs and newlines, e.g. in notes, from breaking the
+// alignment.
+var flattenCell = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+// printTable prints files as plain text with the given columns, for when the
+// interactive table can't or shouldn't be used.
+func printTable(files []utils.File, columns []utils.Column) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var titles []string
+	for _, column := range columns {
+		titles = append(titles, column.Title)
+	}
+	fmt.Fprintln(w, strings.Join(titles, "\t"))
+
+	for _, file := range files {
+		var values []string
+		for _, column := range columns {
+			values = append(values, flattenCell.Replace(column.Value(file)))
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
+}
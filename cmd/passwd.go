@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func newPasswdCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "passwd",
+		Short: "Change your master password",
+		Long:  "Change the master password. Only the vault key is re-wrapped, none of your files are re-encrypted",
+		// Without the vault key there is nothing to re-wrap.
+		PreRunE: requireUnlocked,
+		Run: func(cmd *cobra.Command, args []string) {
+			current, err := promptPassword("Current password: ")
+			if err != nil {
+				fmt.Println("Error reading password:", err)
+				return
+			}
+
+			valid, err := utils.CheckPassword(current)
+			if err != nil {
+				fmt.Println("Error verifying password:", err)
+				return
+			}
+
+			if !valid {
+				fmt.Println("Invalid password.")
+				return
+			}
+
+			newPassword, err := promptPassword("New password: ")
+			if err != nil {
+				fmt.Println("Error reading password:", err)
+				return
+			}
+
+			confirm, err := promptPassword("Confirm new password: ")
+			if err != nil {
+				fmt.Println("Error reading password:", err)
+				return
+			}
+
+			if len(newPassword) == 0 || !bytes.Equal(newPassword, confirm) {
+				color.Yellow("Passwords do not match, aborting...")
+				return
+			}
+
+			if err := utils.ChangePassword(newPassword, vaultKey); err != nil {
+				color.Red(fmt.Sprintf("Could not change password: %v", err))
+				return
+			}
+
+			color.Cyan("Master password changed")
+		},
+	}
+}
+
+var errNoTerminal = errors.New("no terminal to ask for the password on")
+
+// promptPassword reads a password without echoing it. When stdin is a pipe,
+// like in 'pg_dump | hideaway add -', it asks on the controlling terminal
+// instead.
+func promptPassword(prompt string) ([]byte, error) {
+	// stderr, so the prompt doesn't end up in piped output like 'cat'.
+	in, out := os.Stdin, os.Stderr
+
+	if !term.IsTerminal(int(in.Fd())) {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, errNoTerminal
+		}
+		defer tty.Close()
+		in, out = tty, tty
+	}
+
+	fmt.Fprint(out, prompt)
+	password, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	return password, err
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const passwordEnv = "HIDEAWAY_PASSWORD"
+
+func addPasswordFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Int("password-fd", -1, "Read the master password from this file descriptor")
+	cmd.PersistentFlags().String("password-file", "", "Read the master password from the first line of this file")
+	cmd.PersistentFlags().String("password-command", "", "Run this command and read the master password from its output")
+}
+
+// readMasterPassword gets the master password from the first source that is
+// set: --password-fd, --password-file, --password-command, $HIDEAWAY_PASSWORD,
+// and otherwise prompts on the terminal, even when stdin is a pipe.
+func readMasterPassword(cmd *cobra.Command) ([]byte, error) {
+	fd, flagErr := cmd.Flags().GetInt("password-fd")
+	if flagErr != nil {
+		// The REPL's commands don't have the password flags.
+		fd = -1
+	}
+
+	file, _ := cmd.Flags().GetString("password-file")
+	command, _ := cmd.Flags().GetString("password-command")
+
+	env, hasEnv := os.LookupEnv(passwordEnv)
+	// Don't hand the password down to anything we spawn later on.
+	os.Unsetenv(passwordEnv)
+
+	var password []byte
+	var err error
+
+	switch {
+	case fd >= 0:
+		f := os.NewFile(uintptr(fd), "password-fd")
+		if f == nil {
+			return nil, fmt.Errorf("invalid password file descriptor %d", fd)
+		}
+		password, err = readPasswordLine(f)
+		f.Close()
+	case file != "":
+		password, err = readPasswordFile(file)
+	case command != "":
+		password, err = runPasswordCommand(command)
+	case hasEnv:
+		password = []byte(env)
+	default:
+		password, err = promptPassword("Enter password: ")
+		if errors.Is(err, errNoTerminal) {
+			return nil, errors.New("no terminal to ask for the password on, use --password-fd, --password-file, --password-command, $" + passwordEnv + " or a running agent")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("empty password")
+	}
+
+	return password, nil
+}
+
+func readPasswordLine(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading password: %w", err)
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return line, nil
+}
+
+func readPasswordFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening password file: %w", err)
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: %s is readable by other users, run 'chmod 600 %s'\n", path, path)
+	}
+
+	return readPasswordLine(f)
+}
+
+// runPasswordCommand runs a helper through the shell, like git credential
+// helpers. Its stderr and stdin stay attached so it can prompt if it needs to.
+func runPasswordCommand(command string) ([]byte, error) {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("sh", "-c", command)
+	}
+
+	c.Stdin = os.Stdin
+	c.Stderr = os.Stderr
+
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("password command failed: %w", err)
+	}
+
+	return readPasswordLine(bytes.NewReader(out))
+}
@@ -2,8 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/fatih/color"
 	"github.com/sklyerx/hideaway/utils"
@@ -17,12 +15,6 @@ var resetCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var confirmation string
 
-		filePaths := utils.GetAppPaths()
-
-		configPath := filepath.Join(filePaths["userData"], "config.json")
-		databasePath := filepath.Join(filePaths["userData"], "db.enc")
-		dumpPath := filepath.Join(filePaths["userData"], "dump")
-
 		color.Red("Are you absolutely sure you want to reset your Hideaway vault?")
 
 		color.Yellow(`
@@ -41,9 +33,11 @@ By resetting your Hideaway vault all information will be lost such as: Encrypted
 			return
 		}
 
-		os.Remove(configPath)
-		os.Remove(databasePath)
-		os.RemoveAll(dumpPath)
+		if err := utils.ResetVault(); err != nil {
+			color.Red("Could not reset Hideaway: %v", err)
+			return
+		}
+		utils.AgentLock()
 
 		color.Cyan("Successfully reset Hideaway, if you wish to continue using Hideaway run 'hideaway init'")
 	},
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newRestoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <id|name>@<n>",
+		Short: "Make an old version of a file the current one",
+		Long: `Bring back version n of a file, as listed by 'history'. The contents it replaces are kept
+as a new version, so a restore can itself be undone.`,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			at := strings.LastIndex(args[0], "@")
+			if at <= 0 {
+				return fmt.Errorf("expected <id|name>@<version>, got %q", args[0])
+			}
+
+			n, err := strconv.Atoi(args[0][at+1:])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid version %q", args[0][at+1:])
+			}
+
+			file, err := utils.RestoreVersion(args[0][:at], n, vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not restore version %d: %w", n, err)
+			}
+
+			color.Cyan("Restored %s to version %d, it is now version %d", file.OriginalName, n, file.CurrentVersion())
+			return nil
+		},
+	}
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newRmCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "rm <id|name>",
+		Aliases: []string{"delete"},
+		Short:   "Delete a file from your vault",
+		Long:    "Permanently delete a file and its encrypted copy from your vault",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := utils.DeleteFile(args[0], vaultKey); err != nil {
+				return fmt.Errorf("could not delete file: %w", err)
+			}
+
+			color.Cyan("Deleted %s from vault", args[0])
+			return nil
+		},
+	}
+}
@@ -2,61 +2,51 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	utils "github.com/sklyerx/hideaway/utils"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 )
 
-var authenticatedPassword []byte
+// vaultKey is the unwrapped vault data key, set once the master password
+// has been verified.
+var vaultKey []byte
+
+// replIdleTimeout locks the REPL after this long without a vault command when
+// there is no agent to decide, the same as the agent's default.
+const replIdleTimeout = 15 * time.Minute
+
+var (
+	// keyFromAgent is set when the agent holds vaultKey, from then on the
+	// agent decides when the REPL locks.
+	keyFromAgent bool
+	keyLastUsed  time.Time
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "hideaway",
 	Short: "Secure file encryption and storage",
 	Long: `Hideaway encrypts and stores your files securely using a master password.
 Run 'hideaway init' to set up, then 'hideaway' to enter interactive mode.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if !isInitialized() {
-			fmt.Println("Hideaway has not been initialized yet. Run 'hideaway init' first.")
-			return
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireUnlocked(cmd, args); err != nil {
+			return err
 		}
 
-		fmt.Print("Enter password: ")
-
-		password, err := term.ReadPassword(int(os.Stdin.Fd()))
-		if err != nil {
-			fmt.Println("Error reading password:", err)
-			return
-		}
-
-		fmt.Println()
-
-		c, err := utils.ReadConfig()
-		if err != nil {
-			fmt.Println("Error while reading config")
-			return
+		if c, err := utils.ReadConfig(); err == nil && (c.KeyDerivation().Algorithm != utils.KDFArgon2id || c.LegacyVaultKey) {
+			color.Yellow("Your vault uses the older PBKDF2 key derivation, run 'hideaway kdf upgrade' to strengthen it.")
 		}
 
-		valid, err := utils.VerifyPassword(password, c.HashedPassword, c.Salt)
-
-		if err != nil {
-			fmt.Println("Error verifying password:", err)
-			return
-		}
-
-		if !valid {
-			fmt.Println("Invalid password.")
-			return
-		}
-
-		authenticatedPassword = password
-
 		startRepl()
+		return nil
 	},
 }
 
@@ -68,8 +58,94 @@ func Execute() {
 }
 
 func init() {
+	addPasswordFlags(rootCmd)
+
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(resetCmd)
+	rootCmd.AddCommand(kdfCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(lockCmd)
+
+	rootCmd.AddCommand(newAddCmd())
+	rootCmd.AddCommand(newGetCmd())
+	rootCmd.AddCommand(newCatCmd())
+	rootCmd.AddCommand(newEditCmd())
+	rootCmd.AddCommand(newHistoryCmd())
+	rootCmd.AddCommand(newRestoreCmd())
+	rootCmd.AddCommand(newTagCmd())
+	rootCmd.AddCommand(newUntagCmd())
+	rootCmd.AddCommand(newNoteCmd())
+	rootCmd.AddCommand(newAttrCmd())
+	rootCmd.AddCommand(newRmCmd())
+	rootCmd.AddCommand(newListCmd())
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newFsckCmd())
+	rootCmd.AddCommand(newConfigCmd())
+}
+
+// requireUnlocked unlocks the vault, unless it already is (inside the REPL).
+// A running agent is asked for the key first, otherwise the master password
+// is read and the key handed to the agent. Commands that touch the vault use
+// it as their PreRunE.
+func requireUnlocked(cmd *cobra.Command, args []string) error {
+	if vaultKey != nil && stillUnlocked() {
+		keyLastUsed = time.Now()
+		return nil
+	}
+
+	if vaultKey != nil {
+		vaultKey = nil
+		color.Yellow("The vault was locked, unlock it again")
+	}
+
+	// Anything failing from here on isn't a usage mistake.
+	cmd.SilenceUsage = true
+
+	if !isInitialized() {
+		return errors.New("hideaway has not been initialized yet, run 'hideaway init' first")
+	}
+
+	if key, err := utils.AgentGetKey(); err == nil && utils.VaultKeyMatches(key) {
+		vaultKey, keyFromAgent, keyLastUsed = key, true, time.Now()
+		return nil
+	}
+
+	password, err := readMasterPassword(cmd)
+	if err != nil {
+		return fmt.Errorf("error reading password: %w", err)
+	}
+
+	key, err := utils.UnlockVault(password)
+	if err != nil {
+		return fmt.Errorf("error unlocking vault: %w", err)
+	}
+
+	vaultKey, keyLastUsed = key, time.Now()
+
+	// Best effort, there may well be no agent running.
+	keyFromAgent = utils.AgentPutKey(key) == nil
+
+	return nil
+}
+
+// stillUnlocked reports whether the REPL may keep using vaultKey. Once the
+// agent holds the key it has to still hand out the same one, so `hideaway
+// lock` and its idle timeout lock the REPL too. An agent that went away took
+// the key with it. Without one the REPL locks after replIdleTimeout.
+func stillUnlocked() bool {
+	key, err := utils.AgentGetKey()
+	switch {
+	case err == nil:
+		if bytes.Equal(key, vaultKey) {
+			keyFromAgent = true
+			return true
+		}
+		return false
+	case errors.Is(err, utils.ErrNoAgent) && !keyFromAgent:
+		return time.Since(keyLastUsed) < replIdleTimeout
+	default:
+		return false
+	}
 }
 
 func isInitialized() bool {
@@ -89,7 +165,6 @@ func startRepl() {
 	fmt.Println("Welcome to Hideaway Repl!")
 	fmt.Println("Type 'help' for available commands or 'exit' to quit.")
 
-	playgroundCmd := createPlaygroundCommands()
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for {
@@ -117,6 +192,8 @@ func startRepl() {
 			continue
 		}
 
+		// A fresh command tree per line, so flags don't carry over.
+		playgroundCmd := createPlaygroundCommands()
 		playgroundCmd.SetArgs(args)
 		playgroundCmd.SetOut(os.Stdout)
 		playgroundCmd.SetErr(os.Stderr)
@@ -167,6 +244,9 @@ func parseShellArgs(input string) ([]string, error) {
 	return args, nil
 }
 
+// createPlaygroundCommands builds the REPL's commands. 'lock' and the idle
+// timeout clear vaultKey between them, so every command that touches the vault
+// has to go through requireUnlocked in its PreRunE.
 func createPlaygroundCommands() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:           "",
@@ -174,15 +254,25 @@ func createPlaygroundCommands() *cobra.Command {
 		SilenceErrors: true,
 	}
 
-	rootCmd.AddCommand(addCmd)
-	rootCmd.AddCommand(listCmd)
-	rootCmd.AddCommand(statsCmd)
-
-	addCmd.Flags().BoolP("delete", "d", false, "Delete the original file after storing the encrypted version")
-	addCmd.Flags().StringP("name", "n", "", "Add your own custom name (instead of the program interpreting the original file name) for better organization")
+	rootCmd.AddCommand(newAddCmd())
+	rootCmd.AddCommand(newGetCmd())
+	rootCmd.AddCommand(newCatCmd())
+	rootCmd.AddCommand(newEditCmd())
+	rootCmd.AddCommand(newHistoryCmd())
+	rootCmd.AddCommand(newRestoreCmd())
+	rootCmd.AddCommand(newTagCmd())
+	rootCmd.AddCommand(newUntagCmd())
+	rootCmd.AddCommand(newNoteCmd())
+	rootCmd.AddCommand(newAttrCmd())
+	rootCmd.AddCommand(newRmCmd())
+	rootCmd.AddCommand(newListCmd())
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newFsckCmd())
+	rootCmd.AddCommand(newPasswdCmd())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(lockCmd)
 
 	rootCmd.PersistentFlags().ParseErrorsWhitelist.UnknownFlags = true
-	addCmd.Flags().SetInterspersed(true)
 
 	return rootCmd
 }
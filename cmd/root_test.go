@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+// newTestVault creates a vault with the master password "pw" in a temporary
+// directory and returns the path of its config.
+func newTestVault(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	if err := os.MkdirAll(filepath.Join(utils.GetAppPaths()["userData"], "dump"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := utils.NewVaultConfig([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := utils.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(utils.GetAppPaths()["userData"], "config.json")
+}
+
+func runRepl(args ...string) error {
+	repl := createPlaygroundCommands()
+	repl.SetArgs(args)
+	return repl.Execute()
+}
+
+// Commands of the REPL that don't need the vault key.
+var replCommandsWithoutKey = map[string]bool{
+	"lock":       true,
+	"config":     true,
+	"help":       true,
+	"completion": true,
+}
+
+func TestReplCommandsRequireUnlock(t *testing.T) {
+	// Not initialized, so unlocking fails before anything asks for a password.
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	t.Cleanup(func() { vaultKey = nil })
+
+	var check func(cmd *cobra.Command)
+	check = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			check(sub)
+		}
+
+		if !cmd.Runnable() || cmd.Parent() == nil || replCommandsWithoutKey[cmd.Name()] {
+			return
+		}
+
+		t.Run(cmd.CommandPath(), func(t *testing.T) {
+			vaultKey = nil
+
+			if cmd.PreRunE == nil {
+				t.Fatal("runs without unlocking the vault")
+			}
+
+			if err := cmd.PreRunE(cmd, nil); err == nil || vaultKey != nil {
+				t.Errorf("ran with the vault locked (err %v)", err)
+			}
+		})
+	}
+
+	check(createPlaygroundCommands())
+}
+
+func TestPasswdAfterLock(t *testing.T) {
+	configPath := newTestVault(t)
+
+	key, err := utils.UnlockVault([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vaultKey = key
+	t.Cleanup(func() { vaultKey = nil })
+
+	if err := runRepl("lock"); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	if vaultKey != nil {
+		t.Fatal("lock kept the vault key")
+	}
+
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(passwordEnv, "wrong")
+
+	if err := runRepl("passwd"); !errors.Is(err, utils.ErrInvalidPassword) {
+		t.Errorf("passwd: got %v, want %v", err, utils.ErrInvalidPassword)
+	}
+
+	after, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("passwd changed the config")
+	}
+
+	if _, err := utils.UnlockVault([]byte("pw")); err != nil {
+		t.Errorf("the password no longer unlocks the vault: %v", err)
+	}
+}
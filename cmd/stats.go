@@ -2,50 +2,269 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/fatih/color"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/sklyerx/hideaway/utils"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-var statsCmd = &cobra.Command{
-	Use:   "stats",
-	Short: "Get stats about your current vault",
-	Long:  "Get a short breakdown about your vault contents",
-	Run: func(cmd *cobra.Command, args []string) {
-		files, err := utils.GetVaultContent(authenticatedPassword)
+var (
+	panelStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("#874BFD")).
+			Padding(0, 1)
 
-		if err != nil {
-			color.Yellow("Something went wrong while getting vault content")
-			return
-		}
+	panelTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#FAFAFA")).
+			Background(lipgloss.Color("#7D56F4")).
+			Padding(0, 1)
 
-		if len(files) == 0 {
-			fmt.Print("No files in vault")
-			return
-		}
+	labelStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+	barStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4"))
+)
 
-		fmt.Printf("Total items in vault %d\n", len(files))
+// How many rows the breakdowns and the activity chart show at most.
+const (
+	breakdownRows = 8
+	activityRows  = 12
+	barWidth      = 24
+)
 
-		for i, file := range files {
-			if i == 0 {
-				fmt.Println("---------------------------------------")
+func newStatsCmd() *cobra.Command {
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Get stats about your current vault",
+		Long:  "Get a breakdown of your vault: how much space it takes in plain text, encrypted and in dump/, the largest, oldest and newest files, totals per file type and extension, and how much was added each month or week. Prints a dashboard in a terminal and JSON otherwise",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// Scripts get JSON unless they ask for something else.
+			if !cmd.Flags().Changed("output") && !stdoutIsTerminal() {
+				cmd.Flags().Set("output", string(outputJSON))
 			}
+			return requireUnlockedForOutput(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer restoreOutput()
 
-			fmt.Printf(`
-Size:			%d
-MimeType: 		%s
-Extension:		%s
-Name: 			%s
-Dated Added: 	%s
-			`,
-				file.Size, file.MimeType, file.Extension, file.OriginalName, file.DateAdded)
+			showFiles, _ := cmd.Flags().GetBool("files")
+			top, _ := cmd.Flags().GetInt("top")
+			outputStr, _ := cmd.Flags().GetString("output")
+			by, _ := cmd.Flags().GetString("by")
 
-			fmt.Println()
+			format := outputTable
+			if outputStr != "" {
+				var err error
+				if format, err = parseOutputFormat(outputStr); err != nil {
+					return err
+				}
+			}
+
+			if top < 0 {
+				return fmt.Errorf("--top must be 0 or more, got %d", top)
+			}
+
+			if by != "month" && by != "week" {
+				return fmt.Errorf("unknown period %q, use month or week", by)
+			}
+
+			files, err := utils.GetVaultContent(vaultKey)
+
+			if err != nil {
+				return fmt.Errorf("something went wrong while getting vault content: %w", err)
+			}
+
+			summary := utils.Summarize(files, top)
+			if summary.Disk, err = utils.MeasureDump(); err != nil {
+				return fmt.Errorf("measuring dump/: %w", err)
+			}
+
+			if format != outputTable {
+				return printSummary(files, summary, showFiles, format)
+			}
 
-			if i == (len(files) - 1) {
-				fmt.Print("---------------------------------------")
+			if len(files) == 0 {
+				fmt.Print("No files in vault")
+				return nil
 			}
+
+			fmt.Println(renderDashboard(summary, by))
+
+			if !showFiles {
+				return nil
+			}
+
+			config, _ := utils.ReadConfig()
+			utils.SortFiles(files, config.ListSortOrder())
+
+			fmt.Println()
+			return printTable(files, config.ListColumns())
+		},
+	}
+
+	statsCmd.Flags().Bool("files", false, "Also list every file")
+	statsCmd.Flags().Int("top", 5, "How many of the largest files to show")
+	statsCmd.Flags().String("by", "month", "Show what was added per month or week")
+	statsCmd.Flags().StringP("output", "o", "", "Print json, csv or tsv for scripts instead of the dashboard (default: json when stdout isn't a terminal)")
+
+	return statsCmd
+}
+
+// printSummary prints the stats in a machine-readable format. CSV and TSV
+// hold the totals per MIME type, or every file with showFiles.
+func printSummary(files []utils.File, summary utils.VaultSummary, showFiles bool, format outputFormat) error {
+	record := utils.NewSummaryRecord(summary)
+
+	if format == outputJSON {
+		if showFiles {
+			record.FileList = utils.NewFileRecords(files)
 		}
-	},
+		return utils.WriteJSON(os.Stdout, record)
+	}
+
+	if showFiles {
+		return utils.WriteRecords(os.Stdout, utils.NewFileRecords(files), format.separator())
+	}
+
+	return utils.WriteMimeTotals(os.Stdout, record.ByMimeType, format.separator())
+}
+
+// renderDashboard lays the panels out side by side, two to a row, when the
+// terminal is wide enough for it and below each other otherwise.
+func renderDashboard(summary utils.VaultSummary, by string) string {
+	activity := summary.ByMonth
+	if by == "week" {
+		activity = summary.ByWeek
+	}
+
+	rows := [][]string{
+		{overviewPanel(summary), largestPanel(summary.Largest)},
+		{
+			breakdownPanel("By type", summary.ByMime, "(unknown)"),
+			breakdownPanel("By extension", summary.ByExtension, "(none)"),
+		},
+		{activityPanel("Added per "+by, activity)},
+	}
+
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width = 80
+	}
+
+	var lines []string
+	for _, row := range rows {
+		joined := lipgloss.JoinHorizontal(lipgloss.Top, row...)
+		if lipgloss.Width(joined) > width {
+			joined = lipgloss.JoinVertical(lipgloss.Left, row...)
+		}
+		lines = append(lines, joined)
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+func panel(title string, lines []string) string {
+	body := append([]string{panelTitleStyle.Render(title), ""}, lines...)
+	return panelStyle.Render(strings.Join(body, "\n"))
+}
+
+func overviewPanel(summary utils.VaultSummary) string {
+	overhead := "n/a"
+	if summary.Size > 0 {
+		overhead = fmt.Sprintf("%s (%+.1f%%)", formatSignedSize(summary.Overhead()), float64(summary.Overhead())*100/float64(summary.Size))
+	}
+
+	rows := [][2]string{
+		{"Files", fmt.Sprint(summary.Files)},
+		{"Old versions", fmt.Sprint(summary.OldVersions)},
+		{"Plain text", utils.FormatSize(summary.Size)},
+		{"Encrypted", utils.FormatSize(summary.CipherSize)},
+		{"dump/ on disk", fmt.Sprintf("%s in %d files", utils.FormatSize(summary.Disk.Bytes), summary.Disk.Files)},
+		{"Overhead", overhead},
+		{"Oldest", fileDate(summary.Oldest)},
+		{"Newest", fileDate(summary.Newest)},
+	}
+
+	var lines []string
+	for _, row := range rows {
+		lines = append(lines, labelStyle.Render(fmt.Sprintf("%-14s", row[0]))+row[1])
+	}
+
+	return panel("Overview", lines)
+}
+
+func fileDate(file *utils.File) string {
+	return fmt.Sprintf("%s  %s", file.DateAdded.Format("2006-01-02"), truncateString(file.OriginalName, 30))
+}
+
+func formatSignedSize(n int64) string {
+	if n < 0 {
+		return "-" + utils.FormatSize(-n)
+	}
+	return "+" + utils.FormatSize(n)
+}
+
+func largestPanel(files []utils.File) string {
+	var lines []string
+	for _, file := range files {
+		lines = append(lines, fmt.Sprintf("%10s  %s", utils.FormatSize(file.Size), truncateString(file.OriginalName, 30)))
+	}
+
+	return panel("Largest", lines)
+}
+
+// breakdownPanel shows the biggest totals, and the rest of them added up as
+// "other".
+func breakdownPanel(title string, totals []utils.Total, empty string) string {
+	var lines []string
+	var other utils.Total
+
+	for i, total := range totals {
+		if i >= breakdownRows-1 && len(totals) > breakdownRows {
+			other.Count += total.Count
+			other.Size += total.Size
+			continue
+		}
+
+		key := total.Key
+		if key == "" {
+			key = empty
+		}
+		lines = append(lines, fmt.Sprintf("%10s  %5d  %s", utils.FormatSize(total.Size), total.Count, truncateString(key, 30)))
+	}
+
+	if other.Count > 0 {
+		lines = append(lines, labelStyle.Render(fmt.Sprintf("%10s  %5d  other", utils.FormatSize(other.Size), other.Count)))
+	}
+
+	return panel(title, lines)
+}
+
+// activityPanel charts the files added in the last periods, bars scaled to the
+// busiest of them.
+func activityPanel(title string, periods []utils.PeriodTotal) string {
+	if len(periods) > activityRows {
+		periods = periods[len(periods)-activityRows:]
+	}
+
+	most := 0
+	for _, p := range periods {
+		most = max(most, p.Count)
+	}
+
+	var lines []string
+	for _, p := range periods {
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("█", (p.Count*barWidth+most-1)/most)
+		}
+		// Pad before styling, the escape codes would throw the width off.
+		bar = barStyle.Render(bar + strings.Repeat(" ", barWidth-utf8.RuneCountInString(bar)))
+		lines = append(lines, fmt.Sprintf("%-8s  %s  %5d  %10s", p.Period, bar, p.Count, utils.FormatSize(p.Size)))
+	}
+
+	return panel(title, lines)
 }
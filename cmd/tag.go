@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sklyerx/hideaway/utils"
+	"github.com/spf13/cobra"
+)
+
+func newTagCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "tag <id|name> <tag>...",
+		Short:   "Tag a file in your vault",
+		Long:    "Add one or more tags to a file, then find it again with 'list --tag <tag>'. Tags can't contain spaces or commas.",
+		Args:    cobra.MinimumNArgs(2),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := utils.TagFile(args[0], args[1:], vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not tag file: %w", err)
+			}
+
+			color.Cyan("Tagged %s: %s", file.OriginalName, strings.Join(file.Tags, ", "))
+			return nil
+		},
+	}
+}
+
+func newUntagCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "untag <id|name> <tag>...",
+		Short:   "Remove tags from a file in your vault",
+		Args:    cobra.MinimumNArgs(2),
+		PreRunE: requireUnlocked,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := utils.UntagFile(args[0], args[1:], vaultKey)
+			if err != nil {
+				return fmt.Errorf("could not untag file: %w", err)
+			}
+
+			if len(file.Tags) == 0 {
+				color.Cyan("%s has no tags left", file.OriginalName)
+				return nil
+			}
+
+			color.Cyan("Tagged %s: %s", file.OriginalName, strings.Join(file.Tags, ", "))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* AGENT PROTOCOL
+One JSON request and one JSON response per connection over a Unix socket at
+`<userData>/agent/agent.sock`. The socket is 0600 inside a 0700 directory, so
+only the owner can talk to it.
+
+- get:  returns the vault key, or `locked` if the agent holds none
+- put:  stores the vault key
+- lock: forgets the vault key
+
+The key is forgotten after the idle timeout, every get or put resets it.
+*/
+
+var (
+	ErrAgentLocked  = errors.New("agent is locked")
+	ErrNoAgent      = errors.New("agent is not running")
+	ErrAgentRunning = errors.New("agent is already running")
+)
+
+type agentRequest struct {
+	Op  string `json:"op"`
+	Key []byte `json:"key,omitempty"`
+}
+
+type agentResponse struct {
+	Key    []byte `json:"key,omitempty"`
+	Locked bool   `json:"locked,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type agent struct {
+	mu      sync.Mutex
+	key     []byte
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func AgentSocketPath() string {
+	return filepath.Join(GetAppPaths()["userData"], "agent", "agent.sock")
+}
+
+// RunAgent serves the vault key over the agent socket until ctx is done.
+func RunAgent(ctx context.Context, timeout time.Duration) error {
+	socketPath := AgentSocketPath()
+	socketDir := filepath.Dir(socketPath)
+
+	if err := os.MkdirAll(socketDir, 0700); err != nil {
+		return fmt.Errorf("creating agent directory: %w", err)
+	}
+
+	if err := os.Chmod(socketDir, 0700); err != nil {
+		return fmt.Errorf("securing agent directory: %w", err)
+	}
+
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		conn.Close()
+		return ErrAgentRunning
+	}
+
+	// Left behind by an agent that didn't shut down cleanly.
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on agent socket: %w", err)
+	}
+	defer os.Remove(socketPath)
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("securing agent socket: %w", err)
+	}
+
+	a := &agent{timeout: timeout}
+	defer a.lock()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accepting agent connection: %w", err)
+		}
+
+		go a.serve(conn)
+	}
+}
+
+func (a *agent) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	var res agentResponse
+
+	switch req.Op {
+	case "get":
+		res.Key = a.get()
+		res.Locked = res.Key == nil
+	case "put":
+		if len(req.Key) != vaultKeySize {
+			res.Error = "invalid key"
+			break
+		}
+		a.put(req.Key)
+	case "lock":
+		a.lock()
+	default:
+		res.Error = fmt.Sprintf("unknown op %q", req.Op)
+	}
+
+	json.NewEncoder(conn).Encode(res)
+}
+
+func (a *agent) get() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.key == nil {
+		return nil
+	}
+
+	a.touch()
+	return append([]byte(nil), a.key...)
+}
+
+func (a *agent) put(key []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	wipe(a.key)
+	a.key = append([]byte(nil), key...)
+	a.touch()
+}
+
+func (a *agent) lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+
+	wipe(a.key)
+	a.key = nil
+}
+
+// touch restarts the idle timeout, the caller holds a.mu.
+func (a *agent) touch() {
+	if a.timeout <= 0 {
+		return
+	}
+
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+
+	a.timer = time.AfterFunc(a.timeout, a.lock)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func callAgent(req agentRequest) (agentResponse, error) {
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), time.Second)
+	if err != nil {
+		return agentResponse{}, ErrNoAgent
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return agentResponse{}, fmt.Errorf("talking to agent: %w", err)
+	}
+
+	var res agentResponse
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return agentResponse{}, fmt.Errorf("talking to agent: %w", err)
+	}
+
+	if res.Error != "" {
+		return agentResponse{}, fmt.Errorf("agent: %s", res.Error)
+	}
+
+	return res, nil
+}
+
+// AgentGetKey asks a running agent for the vault key.
+func AgentGetKey() ([]byte, error) {
+	res, err := callAgent(agentRequest{Op: "get"})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Locked {
+		return nil, ErrAgentLocked
+	}
+
+	return res.Key, nil
+}
+
+// AgentPutKey hands the vault key to a running agent.
+func AgentPutKey(key []byte) error {
+	_, err := callAgent(agentRequest{Op: "put", Key: key})
+	return err
+}
+
+// AgentLock makes a running agent forget the vault key.
+func AgentLock() error {
+	_, err := callAgent(agentRequest{Op: "lock"})
+	return err
+}
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Collection is a directory added with `add -r`. Each of its files is a
+// normal record pointing back to it through CollectionId and RelativePath.
+type Collection struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	OriginalPath string    `json:"original_path"`
+	DateAdded    time.Time `json:"date_added"`
+	// Dirs lists every directory of the tree relative to the collection
+	// root, so empty ones come back on retrieval too.
+	Dirs []string `json:"dirs"`
+}
+
+var ErrNotFound = errors.New("not found")
+
+// EncryptDirectory walks dir and encrypts every regular file in it. Paths are
+// stored relative to dir with forward slashes. If any file fails, the blobs
+// written so far are removed again, so a collection is stored whole or not at
+// all.
+func EncryptDirectory(dir string, outPath string, vaultKey []byte) (Collection, []File, error) {
+	collection := Collection{
+		Id:           uuid.New().String(),
+		Name:         filepath.Base(dir),
+		OriginalPath: dir,
+		DateAdded:    time.Now(),
+	}
+
+	var files []File
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel != "." {
+				collection.Dirs = append(collection.Dirs, filepath.ToSlash(rel))
+			}
+			return nil
+		}
+
+		// Symlinks, sockets, devices and the like have no content to store.
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		err, file := EncryptFile(path, outPath, "", vaultKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+
+		file.CollectionId = collection.Id
+		file.RelativePath = filepath.ToSlash(rel)
+		files = append(files, file)
+
+		return nil
+	})
+
+	if err != nil {
+		for _, file := range files {
+			os.Remove(blobPath(file.Id))
+		}
+		return Collection{}, nil, err
+	}
+
+	return collection, files, nil
+}
+
+// AddCollection records a collection and all of its files in one go.
+func AddCollection(collection Collection, files []File, vaultKey []byte) error {
+	var redundant []string
+
+	err := mutateStorage(vaultKey, func(data *Storage) error {
+		data.Collections = append(data.Collections, collection)
+		_, redundant = data.addFiles(files, false, RetentionPolicy{})
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	removeBlobs(redundant)
+
+	return nil
+}
+
+// RetrieveCollection rebuilds the directory tree of the collection with the
+// given ID or name where opts point at, by default next to where it was added
+// from. The conflict policy applies to the collection's root directory, with
+// overwrite the tree is restored into the existing one.
+func RetrieveCollection(collectionId string, vaultKey []byte, opts RetrieveOptions) (string, error) {
+	data, err := readStorage(vaultKey)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("collection with id %s %w", collectionId, ErrNotFound)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	found, err := findCollection(data.Collections, collectionId)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := opts.target(found.Name, found.OriginalPath)
+	if err != nil {
+		return "", err
+	}
+
+	root, err = resolveConflict(root, opts.conflictPolicy())
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", root, err)
+	}
+
+	for _, dir := range found.Dirs {
+		target, err := collectionPath(root, dir)
+		if err != nil {
+			return "", err
+		}
+
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return "", fmt.Errorf("creating %s: %w", dir, err)
+		}
+	}
+
+	for _, file := range data.Files {
+		if file.CollectionId != found.Id {
+			continue
+		}
+
+		target, err := collectionPath(root, file.RelativePath)
+		if err != nil {
+			return "", err
+		}
+
+		contentKey, err := file.ContentKey(vaultKey)
+		if err != nil {
+			return "", err
+		}
+
+		if err := DecryptFile(blobPath(file.Blob()), target, contentKey, file.Blob()); err != nil {
+			return "", fmt.Errorf("restoring %s: %w", file.RelativePath, err)
+		}
+	}
+
+	return root, nil
+}
+
+// findCollection looks a collection up by its ID, or by its name when that is
+// unique.
+func findCollection(collections []Collection, idOrName string) (*Collection, error) {
+	for i := range collections {
+		if collections[i].Id == idOrName {
+			return &collections[i], nil
+		}
+	}
+
+	var matches []string
+	var found *Collection
+	for i := range collections {
+		if collections[i].Name == idOrName {
+			matches = append(matches, collections[i].Id)
+			found = &collections[i]
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("collection %s %w", idOrName, ErrNotFound)
+	case 1:
+		return found, nil
+	default:
+		return nil, fmt.Errorf("%d collections are named %s, use one of their ids: %s", len(matches), idOrName, strings.Join(matches, ", "))
+	}
+}
+
+// collectionPath resolves a stored relative path under root, refusing
+// anything that would land outside of it.
+func collectionPath(root, rel string) (string, error) {
+	local := filepath.FromSlash(rel)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("refusing to restore %q outside of the collection", rel)
+	}
+
+	return filepath.Join(root, local), nil
+}
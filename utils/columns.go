@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Column is one of the columns `list` can show, Key is the name used in the
+// config and on the command line.
+type Column struct {
+	Key   string
+	Title string
+	Value func(File) string
+}
+
+const dateFormat = "2006-01-02 15:04:05"
+
+var Columns = []Column{
+	{"id", "Id", func(f File) string { return f.Id }},
+	{"name", "Name", func(f File) string { return f.OriginalName }},
+	{"path", "Original Path", func(f File) string { return f.OriginalPath }},
+	{"added", "Date Added", func(f File) string { return f.DateAdded.Format(dateFormat) }},
+	{"modified", "Modified", func(f File) string { return f.Modified().Format(dateFormat) }},
+	{"type", "Mime Type", func(f File) string { return f.MimeType }},
+	{"ext", "Extension", func(f File) string { return f.Extension }},
+	{"size", "Size", func(f File) string { return FormatSize(f.Size) }},
+	{"tags", "Tags", func(f File) string { return strings.Join(f.Tags, ", ") }},
+	{"note", "Note", func(f File) string { return f.Note }},
+	{"collection", "Collection", func(f File) string { return f.CollectionId }},
+	{"version", "Version", func(f File) string { return strconv.Itoa(f.CurrentVersion()) }},
+}
+
+var DefaultColumns = []string{"id", "name", "added", "type", "size", "tags"}
+
+func columnKeys() []string {
+	var keys []string
+	for _, c := range Columns {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// FindColumns looks up columns by key, in the order given.
+func FindColumns(keys []string) ([]Column, error) {
+	var found []Column
+	for _, key := range keys {
+		i := slices.IndexFunc(Columns, func(c Column) bool { return c.Key == key })
+		if i == -1 {
+			return nil, fmt.Errorf("unknown column %q, use %s", key, strings.Join(columnKeys(), ", "))
+		}
+		found = append(found, Columns[i])
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no columns given")
+	}
+
+	return found, nil
+}
+
+// ParseColumns parses a comma separated list of column keys.
+func ParseColumns(s string) ([]Column, error) {
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, strings.ToLower(key))
+		}
+	}
+
+	return FindColumns(keys)
+}
+
+// ListColumns returns the columns `list` shows, the defaults unless the config
+// names valid ones.
+func (c Config) ListColumns() []Column {
+	if columns, err := FindColumns(c.ListColumnKeys); err == nil {
+		return columns
+	}
+
+	columns, _ := FindColumns(DefaultColumns)
+	return columns
+}
+
+type SortKey string
+
+const (
+	SortName SortKey = "name"
+	SortDate SortKey = "date"
+	SortSize SortKey = "size"
+	SortType SortKey = "type"
+)
+
+var SortKeys = []SortKey{SortName, SortDate, SortSize, SortType}
+
+// SortOrder is how `list` sorts files, written like "size" or "size-desc".
+// The zero value sorts by date, oldest first, the order files were added in.
+type SortOrder struct {
+	Key  SortKey
+	Desc bool
+}
+
+func ParseSortOrder(s string) (SortOrder, error) {
+	key, dir, _ := strings.Cut(strings.ToLower(s), "-")
+
+	order := SortOrder{Key: SortKey(key)}
+	if !slices.Contains(SortKeys, order.Key) {
+		return SortOrder{}, fmt.Errorf("unknown sort %q, use name, date, size or type, optionally followed by -asc or -desc", s)
+	}
+
+	switch dir {
+	case "", "asc":
+	case "desc":
+		order.Desc = true
+	default:
+		return SortOrder{}, fmt.Errorf("unknown sort direction %q, use asc or desc", dir)
+	}
+
+	return order, nil
+}
+
+func (o SortOrder) key() SortKey {
+	if o.Key == "" {
+		return SortDate
+	}
+	return o.Key
+}
+
+func (o SortOrder) String() string {
+	if o.Desc {
+		return string(o.key()) + "-desc"
+	}
+	return string(o.key())
+}
+
+// Next returns the order by the next key, in the same direction.
+func (o SortOrder) Next() SortOrder {
+	i := slices.Index(SortKeys, o.key())
+	return SortOrder{Key: SortKeys[(i+1)%len(SortKeys)], Desc: o.Desc}
+}
+
+// Column returns the key of the column the order sorts by.
+func (o SortOrder) Column() string {
+	if o.key() == SortDate {
+		return "added"
+	}
+	return string(o.key())
+}
+
+// SortFiles sorts files in place, ties are broken by name.
+func SortFiles(files []File, order SortOrder) {
+	slices.SortStableFunc(files, func(a, b File) int {
+		var c int
+		switch order.key() {
+		case SortDate:
+			c = a.DateAdded.Compare(b.DateAdded)
+		case SortSize:
+			c = cmp.Compare(a.Size, b.Size)
+		case SortType:
+			c = strings.Compare(strings.ToLower(a.MimeType), strings.ToLower(b.MimeType))
+		}
+
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.OriginalName), strings.ToLower(b.OriginalName))
+		}
+		if order.Desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// ListSortOrder returns the order `list` sorts by, see SortOrder.
+func (c Config) ListSortOrder() SortOrder {
+	order, err := ParseSortOrder(c.ListSort)
+	if err != nil {
+		return SortOrder{}
+	}
+	return order
+}
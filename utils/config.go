@@ -7,14 +7,52 @@ import (
 )
 
 type Config struct {
-	HashedPassword []byte `json:"hashed_password"`
+	// PasswordCheck tells a wrong password apart, see passwordCheck. Vaults
+	// from older versions kept a cheaper Argon2id hash in HashedPassword
+	// instead, it is replaced on their next unlock.
+	PasswordCheck  []byte `json:"password_check,omitempty"`
+	HashedPassword []byte `json:"hashed_password,omitempty"`
 	Salt           []byte `json:"salt"`
+	WrappedKey     []byte `json:"wrapped_key,omitempty"`
+
+	// KDF and KeySalt derive the key that wraps WrappedKey. KeySalt is kept
+	// apart from Salt, which PBKDF2 and the old hash use, so the derived key
+	// can never equal the stored hash.
+	KDF     KDFParams `json:"kdf"`
+	KeySalt []byte    `json:"key_salt,omitempty"`
+
+	// LegacyVaultKey marks a vault key that was itself derived from the old
+	// password with PBKDF2. `kdf upgrade` replaces it with a random one.
+	LegacyVaultKey bool `json:"legacy_vault_key,omitempty"`
+
+	// Preferences, changed with `hideaway config set`, see settings.go.
+	RetrieveDir string         `json:"retrieve_dir,omitempty"`
+	OnConflict  ConflictPolicy `json:"on_conflict,omitempty"`
+
+	KeepVersions     int `json:"keep_versions,omitempty"`
+	KeepVersionsDays int `json:"keep_versions_days,omitempty"`
+
+	ListColumnKeys []string `json:"list_columns,omitempty"`
+	ListSort       string   `json:"list_sort,omitempty"`
 }
 
-func ReadConfig() (Config, error) {
+// KeyDerivation returns the KDF parameters in use. Configs written before
+// they were stored use PBKDF2.
+func (c Config) KeyDerivation() KDFParams {
+	if c.KDF.Algorithm == KDFNone {
+		return PBKDF2Params
+	}
+
+	return c.KDF
+}
+
+func configFilePath() string {
 	filePaths := GetAppPaths()
-	configFilePath := filepath.Join(filePaths["userData"], "config.json")
-	data, err := os.ReadFile(configFilePath)
+	return filepath.Join(filePaths["userData"], "config.json")
+}
+
+func ReadConfig() (Config, error) {
+	data, err := os.ReadFile(configFilePath())
 
 	if err != nil {
 		return Config{}, err
@@ -27,3 +65,12 @@ func ReadConfig() (Config, error) {
 
 	return config, nil
 }
+
+func WriteConfig(config Config) error {
+	jsonData, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(configFilePath(), jsonData, 0600)
+}
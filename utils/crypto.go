@@ -1,8 +1,8 @@
 package utils
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"time"
@@ -20,7 +21,7 @@ import (
 )
 
 func DeriveKey(password, salt []byte) []byte {
-	return pbkdf2.Key(password, salt, 10000, 32, sha256.New)
+	return pbkdf2.Key(password, salt, int(PBKDF2Params.Time), 32, sha256.New)
 }
 
 func Hash(password, salt []byte) ([]byte, error) {
@@ -46,13 +47,9 @@ func VerifyPassword(password []byte, storedHash, salt []byte) (bool, error) {
 	return subtle.ConstantTimeCompare(derivedHash, storedHash) == 1, nil
 }
 
-func EncryptFile(path string, outPath string, newName string, masterKey string) (error, File) {
-	config, err := ReadConfig()
-	if err != nil {
-		fmt.Printf("ERROR: Failed to grab config: %v\n", err)
-		return err, File{}
-	}
-
+// EncryptFile seals the file at path under a fresh random content key, which
+// is returned wrapped by vaultKey in the File record.
+func EncryptFile(path string, outPath string, newName string, vaultKey []byte) (error, File) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -63,45 +60,16 @@ func EncryptFile(path string, outPath string, newName string, masterKey string)
 		return err, File{}
 	}
 
-	fileData, err := os.ReadFile(path)
+	src, err := os.Open(path)
 	if err != nil {
 		fmt.Printf("ERROR: Reading file: %v\n", err)
 		return fmt.Errorf("reading file %w", err), File{}
 	}
+	defer src.Close()
 
-	key := DeriveKey([]byte(masterKey), config.Salt)
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		fmt.Printf("ERROR: Creating cipher: %v\n", err)
-		return fmt.Errorf("creating cipher: %w", err), File{}
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		fmt.Printf("ERROR: Creating GCM: %v\n", err)
-		return fmt.Errorf("creating GCM: %w", err), File{}
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		fmt.Printf("ERROR: Generating nonce: %v\n", err)
-		return fmt.Errorf("generating nonce: %w", err), File{}
-	}
-
-	encrypted := gcm.Seal(nonce, nonce, fileData, nil)
-
-	id := uuid.New().String()
-	outputPath := fmt.Sprintf("%s/%s.enc", outPath, id)
-
-	if err := os.MkdirAll(outPath, 0755); err != nil {
-		fmt.Printf("ERROR: Creating output directory: %v\n", err)
-		return fmt.Errorf("creating output directory: %w", err), File{}
-	}
-
-	err = os.WriteFile(outputPath, encrypted, 0644)
+	fileRecord, err := writeBlob(src, outPath, vaultKey)
 	if err != nil {
-		fmt.Printf("ERROR: Writing encrypted file: %v\n", err)
-		return fmt.Errorf("writing encrypted file: %w", err), File{}
+		return err, File{}
 	}
 
 	fileExtension := filepath.Ext(path)
@@ -119,59 +87,106 @@ func EncryptFile(path string, outPath string, newName string, masterKey string)
 		fileName = fmt.Sprintf("%s%s", newName, fileExtension)
 	}
 
-	fileRecord := File{
-		Id:           id,
-		OriginalName: fileName,
-		OriginalPath: path,
-		DateAdded:    time.Now(),
-		MimeType:     mimeTypeByExtension,
-		Extension:    fileExtension,
-	}
+	fileRecord.OriginalName = fileName
+	fileRecord.OriginalPath = path
+	fileRecord.DateAdded = time.Now()
+	fileRecord.MimeType = mimeTypeByExtension
+	fileRecord.Extension = fileExtension
 
 	return nil, fileRecord
 }
 
-func DecryptFile(inputPath, outputPath, masterKey string) error {
-	config, err := ReadConfig()
-	if err != nil {
-		fmt.Printf("ERROR: Reading config: %v\n", err)
-		return fmt.Errorf("reading config: %w", err)
+// EncryptReader seals everything read from src as a new file called name, for
+// contents that don't come from a file on disk, like stdin. The type is taken
+// from the name's extension or, without one, sniffed from the contents.
+func EncryptReader(src io.Reader, outPath string, name string, vaultKey []byte) (File, error) {
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(512)
+
+	extension := filepath.Ext(name)
+	mimeType := mime.TypeByExtension(extension)
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head)
 	}
 
-	encryptedData, err := os.ReadFile(inputPath)
+	file, err := writeBlob(br, outPath, vaultKey)
 	if err != nil {
-		fmt.Printf("ERROR: Reading encrypted file: %v\n", err)
-		return fmt.Errorf("reading encrypted file: %w", err)
+		return File{}, err
 	}
 
-	key := DeriveKey([]byte(masterKey), config.Salt)
+	file.OriginalName = name
+	file.DateAdded = time.Now()
+	file.MimeType = mimeType
+	file.Extension = extension
 
-	block, err := aes.NewCipher(key)
+	return file, nil
+}
+
+// writeBlob encrypts src to a new blob in outPath under a fresh random content
+// key. The returned record has the blob's ID, sizes, checksums and the wrapped
+// content key filled in.
+func writeBlob(src io.Reader, outPath string, vaultKey []byte) (File, error) {
+	contentKey, wrappedKey, err := newContentKey(vaultKey)
 	if err != nil {
-		fmt.Printf("ERROR: Creating cipher: %v\n", err)
-		return fmt.Errorf("creating cipher: %w", err)
+		fmt.Printf("ERROR: Generating file key: %v\n", err)
+		return File{}, err
+	}
+
+	id := uuid.New().String()
+	outputPath := fmt.Sprintf("%s/%s.enc", outPath, id)
+
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		fmt.Printf("ERROR: Creating output directory: %v\n", err)
+		return File{}, fmt.Errorf("creating output directory: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	dst, err := CreateAtomic(outputPath, 0644)
 	if err != nil {
-		fmt.Printf("ERROR: Creating GCM: %v\n", err)
-		return fmt.Errorf("creating GCM: %w", err)
+		fmt.Printf("ERROR: Writing encrypted file: %v\n", err)
+		return File{}, fmt.Errorf("writing encrypted file: %w", err)
 	}
 
-	nonceSize := gcm.NonceSize()
-	if len(encryptedData) < nonceSize {
-		fmt.Printf("ERROR: Encrypted data too short. Got %d bytes, need at least %d\n", len(encryptedData), nonceSize)
-		return fmt.Errorf("encrypted data too short")
+	digest := newDigestWriter(vaultKey)
+	if err := EncryptStream(dst, io.TeeReader(src, digest), contentKey, VaultKeyParams, BlobContext(id)); err != nil {
+		dst.Abort()
+		fmt.Printf("ERROR: Encrypting file: %v\n", err)
+		return File{}, fmt.Errorf("encrypting file: %w", err)
 	}
 
-	nonce := encryptedData[:nonceSize]
-	encrypted := encryptedData[nonceSize:]
+	encryptedInfo, err := dst.Stat()
+	if err != nil {
+		dst.Abort()
+		fmt.Printf("ERROR: Writing encrypted file: %v\n", err)
+		return File{}, fmt.Errorf("writing encrypted file: %w", err)
+	}
 
-	decryptedData, err := gcm.Open(nil, nonce, encrypted, nil)
+	if err := dst.Commit(); err != nil {
+		fmt.Printf("ERROR: Writing encrypted file: %v\n", err)
+		return File{}, fmt.Errorf("writing encrypted file: %w", err)
+	}
+
+	return File{
+		Id: id,
+		Content: Content{
+			Size:       digest.n,
+			CipherSize: encryptedInfo.Size(),
+			SHA256:     digest.Sum(),
+			ContentMAC: digest.MAC(),
+			WrappedKey: wrappedKey,
+		},
+	}, nil
+}
+
+// DecryptFile opens the blob with the given ID. A blob that was swapped with
+// another one fails with ErrTampered. Whatever is at outputPath is only
+// replaced once the whole blob decrypted.
+func DecryptFile(inputPath, outputPath string, key []byte, blobId string) error {
+	src, err := os.Open(inputPath)
 	if err != nil {
-		fmt.Printf("ERROR: Decryption failed: %v\n", err)
-		return fmt.Errorf("decryption failed: %w", err)
+		fmt.Printf("ERROR: Reading encrypted file: %v\n", err)
+		return fmt.Errorf("reading encrypted file: %w", err)
 	}
+	defer src.Close()
 
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
@@ -179,51 +194,54 @@ func DecryptFile(inputPath, outputPath, masterKey string) error {
 		return fmt.Errorf("creating output directory: %w", err)
 	}
 
-	err = os.WriteFile(outputPath, decryptedData, 0644)
+	dst, err := CreateAtomic(outputPath, 0644)
 	if err != nil {
 		fmt.Printf("ERROR: Writing decrypted file: %v\n", err)
 		return fmt.Errorf("writing decrypted file: %w", err)
 	}
 
-	return nil
-}
+	if err := DecryptStream(dst, src, key, BlobContext(blobId)); err != nil {
+		dst.Abort()
 
-func Encrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
+		// Each file has its own key, so a blob sealed with a different one
+		// belongs to another file and was moved into this one's place.
+		if errors.Is(err, ErrWrongKey) {
+			err = fmt.Errorf("%w: %w", ErrTampered, err)
+		}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
+		fmt.Printf("ERROR: Decryption failed: %v\n", err)
+		return fmt.Errorf("decryption failed: %w", err)
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+	if err := dst.Commit(); err != nil {
+		fmt.Printf("ERROR: Writing decrypted file: %v\n", err)
+		return fmt.Errorf("writing decrypted file: %w", err)
 	}
 
-	encrypted := gcm.Seal(nonce, nonce, data, nil)
-	return encrypted, nil
+	return nil
 }
 
-func Decrypt(encrypted []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
+// BlobContext is the associated data that ties a blob in `dump/` to its ID.
+func BlobContext(blobId string) []byte {
+	return []byte("hideaway blob " + blobId)
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
+// Encrypt seals an in-memory buffer such as the vault database using the same
+// header and stream format as the blobs in `dump/`.
+func Encrypt(data []byte, key []byte, kdf KDFParams) ([]byte, error) {
+	var out bytes.Buffer
+	if err := EncryptStream(&out, bytes.NewReader(data), key, kdf, nil); err != nil {
 		return nil, err
 	}
 
-	nonceSize := gcm.NonceSize()
-	if len(encrypted) < nonceSize {
-		return nil, fmt.Errorf("encrypted too short")
+	return out.Bytes(), nil
+}
+
+func Decrypt(encrypted []byte, key []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := DecryptStream(&out, bytes.NewReader(encrypted), key, nil); err != nil {
+		return nil, err
 	}
 
-	nonce, encrypted := encrypted[:nonceSize], encrypted[nonceSize:]
-	return gcm.Open(nil, nonce, encrypted, nil)
+	return out.Bytes(), nil
 }
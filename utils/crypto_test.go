@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssociatedData(t *testing.T) {
+	key := testKey(t)
+	sealed := seal(t, []byte("secret"), key, BlobContext("a"))
+
+	tests := []struct {
+		name    string
+		tamper  func(b []byte) []byte
+		context []byte
+		wantErr error
+	}{
+		{"same context", func(b []byte) []byte { return b }, BlobContext("a"), nil},
+		{"other blob's context", func(b []byte) []byte { return b }, BlobContext("b"), ErrTampered},
+		{"no context", func(b []byte) []byte { return b }, nil, ErrTampered},
+		{
+			name: "KDF changed in the header",
+			tamper: func(b []byte) []byte {
+				b[8] ^= 1
+				return b
+			},
+			context: BlobContext("a"),
+			wantErr: ErrTampered,
+		},
+		{
+			name: "downgraded to version 2",
+			tamper: func(b []byte) []byte {
+				b[4] = 2
+				return b
+			},
+			context: BlobContext("a"),
+			wantErr: ErrTampered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(bytes.Clone(sealed))
+
+			err := DecryptStream(&bytes.Buffer{}, bytes.NewReader(tampered), key, tt.context)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptFileSwappedBlob(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump")
+	vaultKey := testKey(t)
+
+	var files []File
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err, file := EncryptFile(path, dump, "", vaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	a, b := files[0], files[1]
+	keyA, err := a.ContentKey(vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	pathA := filepath.Join(dump, a.Id+".enc")
+
+	if err := DecryptFile(pathA, out, keyA, a.Id); err != nil {
+		t.Fatalf("DecryptFile: %v", err)
+	}
+
+	if err := DecryptFile(pathA, out, keyA, b.Id); !errors.Is(err, ErrTampered) {
+		t.Errorf("renamed blob: got %v, want %v", err, ErrTampered)
+	}
+
+	if err := os.Rename(filepath.Join(dump, b.Id+".enc"), pathA); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DecryptFile(pathA, out, keyA, a.Id); !errors.Is(err, ErrTampered) {
+		t.Errorf("swapped blob: got %v, want %v", err, ErrTampered)
+	}
+
+	if got, _ := os.ReadFile(out); string(got) != "a" {
+		t.Errorf("output was overwritten with %q", got)
+	}
+}
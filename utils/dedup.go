@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"os"
+)
+
+/* DEDUPLICATION
+- Every record carries a ContentMAC, an HMAC-SHA256 of the plaintext under a
+  key derived from the vault key. Equal files are found by it without `db.enc`
+  revealing which files are equal to anyone who doesn't hold the key.
+- When a new file's MAC matches a stored one, the new record points at the
+  existing blob through BlobId and shares its wrapped content key. The blob the
+  add just wrote is removed once the db is saved.
+- Storage.RefCounts counts the records pointing at each blob, a blob is only
+  removed with the last of them.
+
+The blob keeps the ID it was written under, which is also the context bound to
+its segments, so records sharing it decrypt it with that ID and not their own.
+*/
+
+func newContentMAC(vaultKey []byte) hash.Hash {
+	mac := hmac.New(sha256.New, vaultKey)
+	mac.Write([]byte("hideaway content mac"))
+
+	return hmac.New(sha256.New, mac.Sum(nil))
+}
+
+// Blob returns the ID of the blob in `dump/` that holds the file's contents.
+func (f File) Blob() string {
+	return f.blobOf(f.Content)
+}
+
+// countRefs rebuilds RefCounts from the records and their old versions, for
+// dbs written before it existed and for fsck.
+func (s *Storage) countRefs() {
+	s.RefCounts = map[string]int{}
+	for _, file := range s.Files {
+		for _, blob := range file.blobs() {
+			s.RefCounts[blob]++
+		}
+	}
+}
+
+type AddStatus int
+
+const (
+	Added AddStatus = iota
+	// AddedDuplicate means the contents were already stored and the blob is
+	// shared.
+	AddedDuplicate
+	// AddedVersion means the file was already in the vault and got its new
+	// contents as a new version.
+	AddedVersion
+	// Unchanged means the file was already in the vault with the same
+	// contents.
+	Unchanged
+)
+
+type AddResult struct {
+	File   File
+	Status AddStatus
+}
+
+// storedContents maps the content MACs of everything in the vault, old
+// versions included, to the contents with BlobId filled in.
+func (s *Storage) storedContents() map[string]Content {
+	stored := map[string]Content{}
+	for _, file := range s.Files {
+		for _, c := range file.contents() {
+			if _, ok := stored[c.ContentMAC]; !ok && c.ContentMAC != "" {
+				shared := *c
+				shared.BlobId = file.blobOf(*c)
+				stored[c.ContentMAC] = shared
+			}
+		}
+	}
+	return stored
+}
+
+// dedupe returns the stored contents equal to c, which was just written to
+// its own blob, and whether there were any. Otherwise c is returned with
+// BlobId set and remembered in stored.
+func dedupe(c Content, blob string, stored map[string]Content) (Content, bool) {
+	if existing, ok := stored[c.ContentMAC]; ok && c.ContentMAC != "" {
+		return existing, true
+	}
+
+	c.BlobId = blob
+	if c.ContentMAC != "" {
+		stored[c.ContentMAC] = c
+	}
+	return c, false
+}
+
+// addFiles records files, pointing the ones whose contents are already stored
+// at the existing blob. With asVersions, a file that's already in the vault
+// (the same original path and name) gets the new contents as a new version
+// instead of a second record. It returns the IDs of the blobs that are no
+// longer needed, for the caller to remove once the db is saved.
+func (s *Storage) addFiles(files []File, asVersions bool, policy RetentionPolicy) ([]AddResult, []string) {
+	if s.RefCounts == nil {
+		s.countRefs()
+	}
+
+	stored := s.storedContents()
+
+	var results []AddResult
+	var redundant []string
+
+	for _, file := range files {
+		if existing := s.findSame(file); asVersions && existing != nil && existing.SHA256 == file.SHA256 {
+			redundant = append(redundant, file.Blob())
+			results = append(results, AddResult{*existing, Unchanged})
+			continue
+		}
+
+		content, shared := dedupe(file.Content, file.Blob(), stored)
+		if shared {
+			redundant = append(redundant, file.Blob())
+		}
+
+		if existing := s.findSame(file); asVersions && existing != nil {
+			redundant = append(redundant, s.setContent(existing, content, policy)...)
+			results = append(results, AddResult{*existing, AddedVersion})
+			continue
+		}
+
+		file.Content = content
+		if file.BlobId == file.Id {
+			file.BlobId = ""
+		}
+
+		s.Files = append(s.Files, file)
+		for _, blob := range file.blobs() {
+			s.RefCounts[blob]++
+		}
+
+		status := Added
+		if shared {
+			status = AddedDuplicate
+		}
+		results = append(results, AddResult{file, status})
+	}
+
+	return results, redundant
+}
+
+// findSame returns the newest record of the same file as file, one outside of
+// any collection with the same original path and name.
+func (s *Storage) findSame(file File) *File {
+	if file.CollectionId != "" {
+		return nil
+	}
+
+	for i := len(s.Files) - 1; i >= 0; i-- {
+		existing := &s.Files[i]
+		if existing.CollectionId == "" && existing.OriginalPath == file.OriginalPath && existing.OriginalName == file.OriginalName {
+			return existing
+		}
+	}
+
+	return nil
+}
+
+// unref drops a reference to the blob and reports whether it was the last.
+func (s *Storage) unref(blob string) bool {
+	if s.RefCounts == nil {
+		s.countRefs()
+	}
+
+	s.RefCounts[blob]--
+	if s.RefCounts[blob] > 0 {
+		return false
+	}
+
+	delete(s.RefCounts, blob)
+	return true
+}
+
+func removeBlobs(ids []string) {
+	for _, id := range ids {
+		os.Remove(blobPath(id))
+	}
+}
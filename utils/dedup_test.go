@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestAddDeduplicates(t *testing.T) {
+	newTestVault(t)
+	vaultKey := testKey(t)
+
+	tests := []struct {
+		name       string
+		contents   string
+		wantStatus AddStatus
+		// wantBlob is whether the file ends up in a.txt's blob.
+		wantBlob bool
+	}{
+		{"a.txt", "same", Added, true},
+		{"b.txt", "same", AddedDuplicate, true},
+		{"c.txt", "different", Added, false},
+		{"d.txt", "same", AddedDuplicate, true},
+	}
+
+	var first File
+	for i, tt := range tests {
+		file, err := EncryptReader(bytes.NewReader([]byte(tt.contents)), dumpDir(), tt.name, vaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		added, err := AppendFiles([]File{file}, vaultKey, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := added[0]
+		if i == 0 {
+			first = got.File
+		}
+
+		if got.Status != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, got.Status, tt.wantStatus)
+		}
+
+		if inFirst := got.File.Blob() == first.Blob(); inFirst != tt.wantBlob {
+			t.Errorf("%s: in a.txt's blob is %v, want %v", tt.name, inFirst, tt.wantBlob)
+		}
+
+		// The blob the add wrote goes when an existing one is used instead.
+		wantRemoved := tt.wantStatus == AddedDuplicate
+		if _, err := os.Stat(blobPath(file.Id)); os.IsNotExist(err) != wantRemoved {
+			t.Errorf("%s: own blob removed is %v, want %v", tt.name, os.IsNotExist(err), wantRemoved)
+		}
+	}
+
+	data, err := readStorage(vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := data.RefCounts[first.Blob()]; got != 3 {
+		t.Errorf("shared blob has %d references, want 3", got)
+	}
+}
+
+func TestDeleteSharedBlob(t *testing.T) {
+	tests := []struct {
+		name string
+		// oldVersion keeps the shared contents as an old version of a.txt
+		// instead of its current ones.
+		oldVersion bool
+		deletes    []string
+		// wantRefs is the count left after each delete, 0 when the blob is
+		// gone.
+		wantRefs []int
+	}{
+		{"in order", false, []string{"a.txt", "b.txt", "c.txt"}, []int{2, 1, 0}},
+		{"original last", false, []string{"c.txt", "b.txt", "a.txt"}, []int{2, 1, 0}},
+		{"old version", true, []string{"b.txt", "c.txt", "a.txt"}, []int{2, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestVault(t)
+			vaultKey := testKey(t)
+
+			a := addTestFile(t, vaultKey, "a.txt", "shared")
+			blob := a.Blob()
+			addTestFile(t, vaultKey, "b.txt", "shared")
+			addTestFile(t, vaultKey, "c.txt", "shared")
+
+			if tt.oldVersion {
+				file, err := EncryptReader(bytes.NewReader([]byte("edited")), dumpDir(), "a.txt", vaultKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				added, err := AppendFiles([]File{file}, vaultKey, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if added[0].Status != AddedVersion || added[0].File.Blob() == blob {
+					t.Fatalf("a.txt wasn't given new contents: %+v", added[0])
+				}
+			}
+
+			for i, name := range tt.deletes {
+				remaining, err := DeleteFile(name, vaultKey)
+				if err != nil {
+					t.Fatalf("deleting %s: %v", name, err)
+				}
+
+				data, err := readStorage(vaultKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := tt.wantRefs[i]
+				if got := data.RefCounts[blob]; got != want {
+					t.Errorf("after deleting %s: %d references, want %d", name, got, want)
+				}
+
+				_, err = os.Stat(blobPath(blob))
+				if exists := err == nil; exists != (want > 0) {
+					t.Errorf("after deleting %s: blob exists is %v, want %v", name, exists, want > 0)
+				}
+
+				for _, file := range remaining {
+					if err := StreamFile(file.Id, vaultKey, &bytes.Buffer{}); err != nil {
+						t.Errorf("after deleting %s, %s doesn't open: %v", name, file.OriginalName, err)
+					}
+				}
+			}
+		})
+	}
+}
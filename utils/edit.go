@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/* EDITING
+- Decrypt the file into a fresh 0700 directory, on tmpfs (`/dev/shm`) where
+  there is one so the plaintext stays in memory
+- Run the editor on it
+- Encrypt the result to a new blob and make it the record's current version,
+  the record keeps its ID and the old contents become an older version
+- Overwrite everything in the directory, including the editor's swap and
+  backup files, then remove it
+*/
+
+var ErrNotPrivate = errors.New("temporary directory is not private")
+
+// PrivateTempDir creates a directory only we can read, for plaintext that has
+// to exist as a file for a while. It fails with ErrNotPrivate if the result
+// could be read by anyone else.
+func PrivateTempDir() (string, error) {
+	base := os.TempDir()
+	if runtime.GOOS == "linux" {
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			base = "/dev/shm"
+		}
+	}
+
+	dir, err := os.MkdirTemp(base, "hideaway-")
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkPrivate(dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("%w: %w", ErrNotPrivate, err)
+	}
+
+	return dir, nil
+}
+
+// RemoveSecurely overwrites every file under dir with zeros before removing
+// it. It's best effort on journaling and copy-on-write filesystems, which is
+// why plaintext is kept on tmpfs where we can.
+func RemoveSecurely(dir string) error {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			overwriteFile(path)
+		}
+		return nil
+	})
+
+	return os.RemoveAll(dir)
+}
+
+func overwriteFile(path string) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	zeros := make([]byte, StreamChunkSize)
+	for left := info.Size(); left > 0; left -= int64(len(zeros)) {
+		n := int64(len(zeros))
+		if left < n {
+			n = left
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			return
+		}
+	}
+
+	f.Sync()
+}
+
+// FindFile looks a file up by its ID, or by its name when that is unique.
+func FindFile(idOrName string, vaultKey []byte) (File, error) {
+	data, err := readStorage(vaultKey)
+	if os.IsNotExist(err) {
+		return File{}, fmt.Errorf("file %s %w", idOrName, ErrNotFound)
+	}
+
+	if err != nil {
+		return File{}, err
+	}
+
+	found, err := findFile(data.Files, idOrName)
+	if err != nil {
+		return File{}, err
+	}
+
+	return *found, nil
+}
+
+// ReplaceFile stores the contents of path as a new version of the file with
+// the given ID. It reports false without changing anything when the contents
+// are the same as before.
+func ReplaceFile(fileId string, path string, vaultKey []byte) (bool, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	config, err := ReadConfig()
+	if err != nil {
+		return false, fmt.Errorf("reading config: %w", err)
+	}
+
+	blob, err := writeBlob(src, dumpDir(), vaultKey)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	var redundant []string
+
+	err = mutateStorage(vaultKey, func(data *Storage) error {
+		found, err := findFile(data.Files, fileId)
+		if err != nil {
+			return err
+		}
+
+		if found.SHA256 == blob.SHA256 {
+			return nil
+		}
+
+		changed = true
+		redundant = data.replaceContents(found, blob, config.Retention())
+		return nil
+	})
+
+	if err != nil || !changed {
+		os.Remove(blobPath(blob.Id))
+		return false, err
+	}
+
+	removeBlobs(redundant)
+
+	return true, nil
+}
+
+// replaceContents makes the freshly written blob, or a stored blob with the
+// same contents, the current contents of file. It returns the blobs that are
+// no longer needed.
+func (s *Storage) replaceContents(file *File, blob File, policy RetentionPolicy) []string {
+	if s.RefCounts == nil {
+		s.countRefs()
+	}
+
+	var redundant []string
+
+	content, shared := dedupe(blob.Content, blob.Id, s.storedContents())
+	if shared {
+		redundant = append(redundant, blob.Id)
+	}
+
+	return append(redundant, s.setContent(file, content, policy)...)
+}
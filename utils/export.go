@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* EXPORT
+FileRecord is what `list` and `stats` print for scripts. It is kept apart
+from File so the db can change without breaking them: fields are only ever
+added, never renamed or removed. Wrapped keys and content MACs never leave the
+vault.
+
+In CSV and TSV the columns are RecordFields, in that order, under a header
+row. SummaryRecord is the same for `stats`, its CSV and TSV are the totals per
+MIME type. Tags are joined with ";" and attributes written as "key=value" pairs
+joined with ";". Dates are RFC 3339 and sizes are in bytes, everywhere.
+*/
+
+type FileRecord struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	OriginalPath string            `json:"original_path"`
+	CollectionId string            `json:"collection_id"`
+	RelativePath string            `json:"relative_path"`
+	MimeType     string            `json:"mime_type"`
+	Extension    string            `json:"extension"`
+	Size         int64             `json:"size"`
+	StoredSize   int64             `json:"stored_size"`
+	SHA256       string            `json:"sha256"`
+	DateAdded    time.Time         `json:"date_added"`
+	DateModified time.Time         `json:"date_modified"`
+	Version      int               `json:"version"`
+	OldVersions  int               `json:"old_versions"`
+	Tags         []string          `json:"tags"`
+	Note         string            `json:"note"`
+	Attributes   map[string]string `json:"attributes"`
+}
+
+// RecordFields are the CSV and TSV columns, named like the JSON fields.
+var RecordFields = []string{
+	"id", "name", "original_path", "collection_id", "relative_path", "mime_type", "extension",
+	"size", "stored_size", "sha256", "date_added", "date_modified", "version", "old_versions",
+	"tags", "note", "attributes",
+}
+
+func NewFileRecord(file File) FileRecord {
+	record := FileRecord{
+		Id:           file.Id,
+		Name:         file.OriginalName,
+		OriginalPath: file.OriginalPath,
+		CollectionId: file.CollectionId,
+		RelativePath: file.RelativePath,
+		MimeType:     file.MimeType,
+		Extension:    file.Extension,
+		Size:         file.Size,
+		StoredSize:   file.CipherSize,
+		SHA256:       file.SHA256,
+		DateAdded:    file.DateAdded,
+		DateModified: file.Modified(),
+		Version:      file.CurrentVersion(),
+		OldVersions:  len(file.Versions),
+		Tags:         file.Tags,
+		Note:         file.Note,
+		Attributes:   file.Attributes,
+	}
+
+	// Scripts get empty lists and objects rather than nulls.
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	if record.Attributes == nil {
+		record.Attributes = map[string]string{}
+	}
+
+	return record
+}
+
+func NewFileRecords(files []File) []FileRecord {
+	records := []FileRecord{}
+	for _, file := range files {
+		records = append(records, NewFileRecord(file))
+	}
+	return records
+}
+
+// fields returns the record's values in the order of RecordFields.
+func (r FileRecord) fields() []string {
+	var attrs []string
+	for _, key := range slices.Sorted(maps.Keys(r.Attributes)) {
+		attrs = append(attrs, key+"="+r.Attributes[key])
+	}
+
+	return []string{
+		r.Id, r.Name, r.OriginalPath, r.CollectionId, r.RelativePath, r.MimeType, r.Extension,
+		strconv.FormatInt(r.Size, 10), strconv.FormatInt(r.StoredSize, 10), r.SHA256,
+		r.DateAdded.Format(time.RFC3339), r.DateModified.Format(time.RFC3339),
+		strconv.Itoa(r.Version), strconv.Itoa(r.OldVersions),
+		strings.Join(r.Tags, ";"), r.Note, strings.Join(attrs, ";"),
+	}
+}
+
+// SummaryRecord is what `stats` prints for scripts. Oldest and Newest are
+// null in an empty vault, FileList is only filled in when asked for.
+type SummaryRecord struct {
+	Files        int            `json:"files"`
+	Size         int64          `json:"size"`
+	StoredSize   int64          `json:"stored_size"`
+	OldVersions  int            `json:"old_versions"`
+	Largest      []FileRecord   `json:"largest"`
+	ByMimeType   []MimeRecord   `json:"by_mime_type"`
+	ByExtension  []ExtRecord    `json:"by_extension"`
+	AddedByMonth []PeriodRecord `json:"added_by_month"`
+	AddedByWeek  []PeriodRecord `json:"added_by_week"`
+	Oldest       *FileRecord    `json:"oldest"`
+	Newest       *FileRecord    `json:"newest"`
+	DumpFiles    int            `json:"dump_files"`
+	DumpSize     int64          `json:"dump_size"`
+	Overhead     int64          `json:"overhead"`
+	FileList     []FileRecord   `json:"file_list,omitempty"`
+}
+
+type MimeRecord struct {
+	MimeType string `json:"mime_type"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`
+}
+
+type ExtRecord struct {
+	Extension string `json:"extension"`
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"`
+}
+
+// PeriodRecord is what was added in a month ("2025-03") or an ISO week
+// ("2025-W09"), Start is its first day.
+type PeriodRecord struct {
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	Files  int       `json:"files"`
+	Size   int64     `json:"size"`
+}
+
+func NewSummaryRecord(summary VaultSummary) SummaryRecord {
+	record := SummaryRecord{
+		Files:       summary.Files,
+		Size:        summary.Size,
+		StoredSize:  summary.CipherSize,
+		OldVersions: summary.OldVersions,
+		Largest:     NewFileRecords(summary.Largest),
+		ByMimeType:  []MimeRecord{},
+		ByExtension: []ExtRecord{},
+		DumpFiles:   summary.Disk.Files,
+		DumpSize:    summary.Disk.Bytes,
+		Overhead:    summary.Overhead(),
+	}
+
+	for _, total := range summary.ByMime {
+		record.ByMimeType = append(record.ByMimeType, MimeRecord{total.Key, total.Count, total.Size})
+	}
+	for _, total := range summary.ByExtension {
+		record.ByExtension = append(record.ByExtension, ExtRecord{total.Key, total.Count, total.Size})
+	}
+
+	record.AddedByMonth = newPeriodRecords(summary.ByMonth)
+	record.AddedByWeek = newPeriodRecords(summary.ByWeek)
+
+	if summary.Oldest != nil {
+		oldest, newest := NewFileRecord(*summary.Oldest), NewFileRecord(*summary.Newest)
+		record.Oldest, record.Newest = &oldest, &newest
+	}
+
+	return record
+}
+
+func newPeriodRecords(periods []PeriodTotal) []PeriodRecord {
+	records := []PeriodRecord{}
+	for _, p := range periods {
+		records = append(records, PeriodRecord{p.Period, p.Start, p.Count, p.Size})
+	}
+	return records
+}
+
+// MimeFields are the CSV and TSV columns of the totals per MIME type.
+var MimeFields = []string{"mime_type", "files", "size"}
+
+// WriteMimeTotals writes the totals per MIME type as CSV, or TSV when sep is
+// '\t', with a header row.
+func WriteMimeTotals(w io.Writer, totals []MimeRecord, sep rune) error {
+	rows := [][]string{MimeFields}
+	for _, total := range totals {
+		rows = append(rows, []string{total.MimeType, strconv.Itoa(total.Files), strconv.FormatInt(total.Size, 10)})
+	}
+
+	return WriteTable(w, rows, sep)
+}
+
+// WriteJSON writes v as indented JSON.
+func WriteJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteRecords writes records as CSV, or as TSV when sep is '\t', with a
+// header row. Values that contain the separator, quotes or newlines are
+// quoted.
+func WriteRecords(w io.Writer, records []FileRecord, sep rune) error {
+	rows := [][]string{RecordFields}
+	for _, record := range records {
+		rows = append(rows, record.fields())
+	}
+
+	return WriteTable(w, rows, sep)
+}
+
+// WriteTable writes rows as CSV, or TSV when sep is '\t'.
+func WriteTable(w io.Writer, rows [][]string, sep rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = sep
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
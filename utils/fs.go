@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// AtomicFile is written to a temporary file next to its destination and only
+// takes the destination's place on Commit, so readers never see a partially
+// written file, even if we crash or the disk fills up half way.
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+func CreateAtomic(path string, perm os.FileMode) (*AtomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &AtomicFile{File: f, path: path}, nil
+}
+
+// Commit flushes the temporary file to disk and renames it over the
+// destination.
+func (f *AtomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := renameDurable(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort throws the temporary file away and leaves the destination untouched.
+func (f *AtomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := CreateAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return err
+	}
+
+	return f.Commit()
+}
+
+// renameDurable renames oldPath to newPath and makes sure the rename itself
+// reached the disk.
+func renameDurable(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(newPath))
+}
+
+func syncDir(dir string) error {
+	// Windows can't open directories for syncing, renames there are
+	// durable once they return.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* FSCK
+- Every record in `db.enc` needs a blob in `dump/` that decrypts and whose
+  GCM tags all verify.
+- Every blob in `dump/` needs a record.
+
+With repair, records without a blob are dropped, corrupt blobs are moved to
+`<userData>/quarantine` with their record kept in Storage.Quarantine, and
+orphaned blobs are re-registered when the vault key opens them (files added
+before per-file keys) or quarantined when it doesn't. Blobs shared by several
+records are checked once, and the reference counts are rebuilt from the records.
+Old versions are checked like records and dropped when their blob is missing or
+corrupt. A record whose current blob is missing or corrupt goes back to its
+newest old version.
+
+Decrypting every blob takes a while on a big vault, so it happens without the
+vault lock. A repair then takes the lock, reads `db.enc` again and fixes it
+from the results, only decrypting blobs added in the meantime.
+*/
+
+type IssueKind string
+
+const (
+	IssueMissingBlob IssueKind = "missing blob"
+	IssueCorruptBlob IssueKind = "corrupt blob"
+	IssueOrphanBlob  IssueKind = "orphan blob"
+	IssueLeftover    IssueKind = "leftover temp file"
+	IssueRefCount    IssueKind = "wrong reference count"
+)
+
+// orphanGracePeriod protects blobs written by an add that hasn't recorded
+// them in `db.enc` yet.
+const orphanGracePeriod = 5 * time.Minute
+
+type FsckIssue struct {
+	Kind   IssueKind
+	FileId string
+	Name   string
+	Detail string
+	// Fixed says what repair did about the issue, empty if nothing.
+	Fixed string
+}
+
+type FsckReport struct {
+	Checked int
+	Issues  []FsckIssue
+}
+
+func quarantineDir() string {
+	return filepath.Join(GetAppPaths()["userData"], "quarantine")
+}
+
+// CheckVault verifies every record and blob in the vault, and fixes what it
+// can when repair is set.
+func CheckVault(vaultKey []byte, repair bool) (FsckReport, error) {
+	data, err := readStorage(vaultKey)
+	if err != nil && !os.IsNotExist(err) {
+		return FsckReport{}, err
+	}
+
+	checks := &blobChecks{vaultKey: vaultKey, verified: map[string]error{}, recovered: map[string]*File{}}
+
+	report, err := checkStorage(&data, checks, false)
+	if err != nil || !repair || len(report.Issues) == 0 {
+		return report, err
+	}
+
+	unlock, err := lockVault()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	if data, err = readStorage(vaultKey); err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+
+	if report, err = checkStorage(&data, checks, true); err != nil {
+		return report, err
+	}
+
+	if len(report.Issues) > 0 {
+		if err := writeStorage(data, vaultKey); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// blobChecks remembers what decrypting each blob showed, so shared blobs and
+// a repair after the check don't decrypt them again.
+type blobChecks struct {
+	vaultKey  []byte
+	verified  map[string]error
+	recovered map[string]*File
+}
+
+func (c *blobChecks) verify(file File) error {
+	err, ok := c.verified[file.Blob()]
+	if !ok {
+		err = verifyBlob(file, c.vaultKey)
+		c.verified[file.Blob()] = err
+	}
+	return err
+}
+
+func (c *blobChecks) recover(id string, added time.Time) (File, bool) {
+	file, ok := c.recovered[id]
+	if !ok {
+		if recovered, ok := recoverOrphan(id, added, c.vaultKey); ok {
+			file = &recovered
+		}
+		c.recovered[id] = file
+	}
+
+	if file == nil {
+		return File{}, false
+	}
+	return *file, true
+}
+
+// checkStorage checks data against the blobs in `dump/`. With repair it fixes
+// data and the blobs, the caller saves data and has to hold the vault lock.
+func checkStorage(data *Storage, checks *blobChecks, repair bool) (FsckReport, error) {
+	var report FsckReport
+	known := map[string]bool{}
+	quarantined := map[string]bool{}
+	kept := []File{}
+
+	quarantine := func(blob string) error {
+		if quarantined[blob] {
+			return nil
+		}
+		if err := quarantineBlob(blob); err != nil {
+			return err
+		}
+		quarantined[blob] = true
+		return nil
+	}
+
+	for _, file := range data.Files {
+		blob := file.Blob()
+		for _, b := range file.blobs() {
+			known[b] = true
+		}
+		report.Checked++
+
+		var versions []Version
+		for _, v := range file.Versions {
+			report.Checked++
+
+			old, _ := file.AtVersion(v.Number)
+			err := checks.verify(old)
+			if err == nil {
+				versions = append(versions, v)
+				continue
+			}
+
+			issue := FsckIssue{Kind: IssueCorruptBlob, FileId: file.Id, Name: fmt.Sprintf("%s@%d", file.OriginalName, v.Number), Detail: err.Error()}
+			if os.IsNotExist(err) {
+				issue.Kind = IssueMissingBlob
+				issue.Detail = "no blob in dump/"
+			}
+
+			if !repair {
+				versions = append(versions, v)
+			} else if issue.Kind == IssueMissingBlob {
+				issue.Fixed = "removed version"
+			} else {
+				if err := quarantine(old.Blob()); err != nil {
+					return report, err
+				}
+				issue.Fixed = "removed version, blob moved to quarantine"
+			}
+
+			report.Issues = append(report.Issues, issue)
+		}
+		file.Versions = versions
+
+		err := checks.verify(file)
+
+		if err == nil {
+			kept = append(kept, file)
+			continue
+		}
+
+		issue := FsckIssue{FileId: file.Id, Name: file.OriginalName, Detail: err.Error()}
+
+		switch {
+		case os.IsNotExist(err):
+			issue.Kind = IssueMissingBlob
+			issue.Detail = "no blob in dump/"
+			if !repair {
+				kept = append(kept, file)
+			} else if rollBack(&file) {
+				kept = append(kept, file)
+				issue.Fixed = fmt.Sprintf("went back to version %d", file.CurrentVersion())
+			} else {
+				issue.Fixed = "removed record"
+			}
+		default:
+			issue.Kind = IssueCorruptBlob
+			if !repair {
+				kept = append(kept, file)
+				break
+			}
+
+			if err := quarantine(blob); err != nil {
+				return report, err
+			}
+
+			broken := file
+			broken.Versions = nil
+			data.Quarantine = append(data.Quarantine, broken)
+			issue.Fixed = "moved to quarantine"
+
+			if rollBack(&file) {
+				kept = append(kept, file)
+				issue.Fixed += fmt.Sprintf(", went back to version %d", file.CurrentVersion())
+			}
+		}
+
+		report.Issues = append(report.Issues, issue)
+	}
+
+	counted := Storage{Files: data.Files}
+	counted.countRefs()
+
+	if data.RefCounts != nil && !maps.Equal(data.RefCounts, counted.RefCounts) {
+		issue := FsckIssue{Kind: IssueRefCount, Name: "db.enc", Detail: "reference counts don't match the records"}
+		if repair {
+			issue.Fixed = "recounted"
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	entries, err := os.ReadDir(dumpDir())
+	if err != nil && !os.IsNotExist(err) {
+		return report, fmt.Errorf("reading dump: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+
+		recent := time.Since(info.ModTime()) < orphanGracePeriod
+
+		if strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-") {
+			issue := FsckIssue{Kind: IssueLeftover, Name: name, Detail: "left behind by an interrupted write"}
+			if repair && !recent {
+				os.Remove(filepath.Join(dumpDir(), name))
+				issue.Fixed = "removed"
+			}
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+
+		if !strings.HasSuffix(name, ".enc") {
+			continue
+		}
+
+		id := strings.TrimSuffix(name, ".enc")
+		if known[id] {
+			continue
+		}
+
+		issue := FsckIssue{Kind: IssueOrphanBlob, FileId: id, Detail: "not listed in db.enc"}
+
+		switch {
+		case recent:
+			issue.Detail = "not listed in db.enc yet, it may belong to an add in progress"
+		case repair:
+			if file, ok := checks.recover(id, info.ModTime()); ok {
+				kept = append(kept, file)
+				issue.Fixed = fmt.Sprintf("re-registered as %s", file.OriginalName)
+			} else {
+				if err := quarantineBlob(id); err != nil {
+					return report, err
+				}
+				issue.Fixed = "moved to quarantine"
+			}
+		default:
+			// Found out now, so a repair doesn't have to under the lock.
+			checks.recover(id, info.ModTime())
+		}
+
+		report.Issues = append(report.Issues, issue)
+	}
+
+	if repair {
+		data.Files = kept
+		data.countRefs()
+	}
+
+	return report, nil
+}
+
+// rollBack makes the newest old version of file the current one, for when the
+// current blob is lost. It reports false when there is none.
+func rollBack(file *File) bool {
+	if len(file.Versions) == 0 {
+		return false
+	}
+
+	last := file.Versions[len(file.Versions)-1]
+	file.Versions = file.Versions[:len(file.Versions)-1]
+	file.Content = last.Content
+	if file.BlobId == file.Id {
+		file.BlobId = ""
+	}
+	file.Version = last.Number
+	file.DateModified = last.DateAdded
+
+	return true
+}
+
+func verifyBlob(file File, vaultKey []byte) error {
+	contentKey, err := file.ContentKey(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(blobPath(file.Blob()))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = DecryptStream(io.Discard, f, contentKey, BlobContext(file.Blob()))
+	if errors.Is(err, ErrWrongKey) {
+		err = fmt.Errorf("%w: %w", ErrTampered, err)
+	}
+
+	return err
+}
+
+// recoverOrphan builds a new record for a blob that lost its own. Only blobs
+// sealed with the vault key itself can be opened without the record.
+func recoverOrphan(id string, added time.Time, vaultKey []byte) (File, bool) {
+	file := File{
+		Id:           id,
+		OriginalName: "recovered-" + id,
+		DateAdded:    added,
+		MimeType:     "application/octet-stream",
+	}
+
+	if err := fillFileInfo(&file, vaultKey); err != nil {
+		return File{}, false
+	}
+
+	return file, true
+}
+
+func quarantineBlob(id string) error {
+	if err := os.MkdirAll(quarantineDir(), 0700); err != nil {
+		return fmt.Errorf("creating quarantine: %w", err)
+	}
+
+	if err := renameDurable(blobPath(id), filepath.Join(quarantineDir(), id+".enc")); err != nil {
+		return fmt.Errorf("quarantining %s: %w", id, err)
+	}
+
+	return nil
+}
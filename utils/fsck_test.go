@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backdate makes a file in `dump/` older than the orphan grace period.
+func backdate(t *testing.T, path string) {
+	t.Helper()
+
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckVaultRepair(t *testing.T) {
+	tests := []struct {
+		name      string
+		damage    func(t *testing.T, vaultKey []byte, file File)
+		wantKind  IssueKind
+		wantFixed string
+		wantFiles int
+	}{
+		{
+			name: "missing blob",
+			damage: func(t *testing.T, vaultKey []byte, file File) {
+				os.Remove(blobPath(file.Blob()))
+			},
+			wantKind:  IssueMissingBlob,
+			wantFixed: "removed record",
+			wantFiles: 1,
+		},
+		{
+			name: "corrupt blob",
+			damage: func(t *testing.T, vaultKey []byte, file File) {
+				b, err := os.ReadFile(blobPath(file.Blob()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b[len(b)-1] ^= 1
+				os.WriteFile(blobPath(file.Blob()), b, 0644)
+			},
+			wantKind:  IssueCorruptBlob,
+			wantFixed: "moved to quarantine",
+			wantFiles: 1,
+		},
+		{
+			name: "orphan sealed with the vault key",
+			damage: func(t *testing.T, vaultKey []byte, file File) {
+				out, err := os.Create(blobPath("orphan"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				EncryptStream(out, bytes.NewReader([]byte("lost")), vaultKey, VaultKeyParams, BlobContext("orphan"))
+				out.Close()
+				backdate(t, blobPath("orphan"))
+			},
+			wantKind:  IssueOrphanBlob,
+			wantFixed: "re-registered as recovered-orphan",
+			wantFiles: 3,
+		},
+		{
+			name: "orphan that doesn't open",
+			damage: func(t *testing.T, vaultKey []byte, file File) {
+				os.WriteFile(blobPath("orphan"), []byte("garbage"), 0644)
+				backdate(t, blobPath("orphan"))
+			},
+			wantKind:  IssueOrphanBlob,
+			wantFixed: "moved to quarantine",
+			wantFiles: 2,
+		},
+		{
+			name: "leftover temp file",
+			damage: func(t *testing.T, vaultKey []byte, file File) {
+				path := filepath.Join(dumpDir(), ".x.enc.tmp-123")
+				os.WriteFile(path, nil, 0644)
+				backdate(t, path)
+			},
+			wantKind:  IssueLeftover,
+			wantFixed: "removed",
+			wantFiles: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestVault(t)
+			vaultKey := testKey(t)
+
+			file := addTestFile(t, vaultKey, "a.txt", "damaged")
+			addTestFile(t, vaultKey, "b.txt", "fine")
+
+			tt.damage(t, vaultKey, file)
+
+			for _, repair := range []bool{false, true} {
+				report, err := CheckVault(vaultKey, repair)
+				if err != nil {
+					t.Fatalf("CheckVault(repair=%v): %v", repair, err)
+				}
+
+				if len(report.Issues) != 1 || report.Issues[0].Kind != tt.wantKind {
+					t.Fatalf("repair=%v: got issues %+v, want one %q", repair, report.Issues, tt.wantKind)
+				}
+
+				want := ""
+				if repair {
+					want = tt.wantFixed
+				}
+				if got := report.Issues[0].Fixed; got != want {
+					t.Errorf("repair=%v: fixed %q, want %q", repair, got, want)
+				}
+			}
+
+			report, err := CheckVault(vaultKey, false)
+			if err != nil || len(report.Issues) > 0 {
+				t.Errorf("issues left after the repair: %+v (%v)", report.Issues, err)
+			}
+
+			files, err := GetVaultContent(vaultKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != tt.wantFiles {
+				t.Errorf("%d files left, want %d", len(files), tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestCheckVaultLocking(t *testing.T) {
+	newTestVault(t)
+	vaultKey := testKey(t)
+
+	file := addTestFile(t, vaultKey, "a.txt", "contents")
+
+	timeout := LockTimeout
+	LockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { LockTimeout = timeout })
+
+	// Someone else is changing the vault.
+	unlock, err := lockVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	if _, err := CheckVault(vaultKey, false); err != nil {
+		t.Errorf("checking waited for the lock: %v", err)
+	}
+
+	// Nothing to fix, so nothing to lock for.
+	if _, err := CheckVault(vaultKey, true); err != nil {
+		t.Errorf("repairing a healthy vault waited for the lock: %v", err)
+	}
+
+	os.Remove(blobPath(file.Blob()))
+
+	if _, err := CheckVault(vaultKey, true); !errors.Is(err, ErrVaultBusy) {
+		t.Errorf("repair: got %v, want %v", err, ErrVaultBusy)
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/* HEADER FORMAT (version 3)
+- magic "HDWY"           4 bytes
+- version                1 byte
+- cipher ID              1 byte
+- KDF ID                 1 byte
+- KDF threads            1 byte
+- KDF time / iterations  uint32
+- KDF memory (KiB)       uint32
+- chunk size             uint32
+- key ID                 8 bytes
+- nonce prefix           7 bytes
+
+Every `.enc` blob in `dump/` and `db.enc` start with this header. From version
+3 on, every segment authenticates the header bytes plus the blob's context (the
+blob ID for blobs in `dump/`) as associated data. Version 2 has the same layout
+without associated data. Version 1 blobs (magic, version, chunk size, nonce
+prefix) and headerless single-shot blobs are still readable.
+*/
+
+const (
+	FormatVersion = 3
+
+	CipherAES256GCM uint8 = 1
+
+	KDFNone     uint8 = 0
+	KDFPBKDF2   uint8 = 1
+	KDFArgon2id uint8 = 2
+)
+
+const (
+	headerV1Size = 4 + 1 + 4 + streamNoncePrefixSize
+	headerV2Size = 4 + 1 + 1 + 1 + 1 + 4 + 4 + 4 + 8 + streamNoncePrefixSize
+
+	maxChunkSize = 16 * 1024 * 1024
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	ErrWrongKey           = errors.New("blob was encrypted with a different key")
+	ErrTampered           = errors.New("blob failed authentication, it was modified or swapped")
+)
+
+// KDFParams describe how the key that sealed a blob was derived.
+type KDFParams struct {
+	Algorithm uint8  `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+var PBKDF2Params = KDFParams{Algorithm: KDFPBKDF2, Time: 10000}
+
+type Header struct {
+	Version     uint8
+	Cipher      uint8
+	KDF         KDFParams
+	ChunkSize   uint32
+	KeyID       [8]byte
+	NoncePrefix [streamNoncePrefixSize]byte
+}
+
+// KeyID is a short fingerprint of a key, so readers can tell a wrong key
+// apart from a corrupt blob without revealing anything about the key itself.
+func KeyID(key []byte) [8]byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("hideaway key id"))
+
+	var id [8]byte
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+func (h Header) MarshalBinary() []byte {
+	b := make([]byte, 0, headerV2Size)
+	b = append(b, streamMagic...)
+	b = append(b, h.Version, h.Cipher, h.KDF.Algorithm, h.KDF.Threads)
+	b = binary.BigEndian.AppendUint32(b, h.KDF.Time)
+	b = binary.BigEndian.AppendUint32(b, h.KDF.Memory)
+	b = binary.BigEndian.AppendUint32(b, h.ChunkSize)
+	b = append(b, h.KeyID[:]...)
+	b = append(b, h.NoncePrefix[:]...)
+	return b
+}
+
+// ReadHeader parses the header at the start of r. A blob without the magic is
+// reported as version 0, the old single-shot `nonce||ciphertext` format.
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	magic, err := r.Peek(len(streamMagic) + 1)
+	if err != nil || !bytes.Equal(magic[:len(streamMagic)], streamMagic) {
+		return Header{Version: 0, Cipher: CipherAES256GCM, KDF: PBKDF2Params}, nil
+	}
+
+	var h Header
+	h.Version = magic[len(streamMagic)]
+
+	switch h.Version {
+	case 1:
+		b := make([]byte, headerV1Size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return Header{}, ErrTruncated
+		}
+
+		h.Cipher = CipherAES256GCM
+		h.KDF = PBKDF2Params
+		h.ChunkSize = binary.BigEndian.Uint32(b[5:])
+		copy(h.NoncePrefix[:], b[9:])
+	case 2, 3:
+		b := make([]byte, headerV2Size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return Header{}, ErrTruncated
+		}
+
+		h.Cipher = b[5]
+		h.KDF.Algorithm = b[6]
+		h.KDF.Threads = b[7]
+		h.KDF.Time = binary.BigEndian.Uint32(b[8:])
+		h.KDF.Memory = binary.BigEndian.Uint32(b[12:])
+		h.ChunkSize = binary.BigEndian.Uint32(b[16:])
+		copy(h.KeyID[:], b[20:28])
+		copy(h.NoncePrefix[:], b[28:])
+	default:
+		return Header{}, fmt.Errorf("%w %d, upgrade hideaway to read this vault", ErrUnsupportedVersion, h.Version)
+	}
+
+	if h.Cipher != CipherAES256GCM {
+		return Header{}, fmt.Errorf("unsupported cipher %d", h.Cipher)
+	}
+
+	if h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+		return Header{}, fmt.Errorf("invalid chunk size %d", h.ChunkSize)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// sealSegments seals plain the way EncryptStream does, for building blobs in
+// the older formats.
+func sealSegments(t *testing.T, key []byte, prefix [streamNoncePrefixSize]byte, chunkSize int, plain, ad []byte) []byte {
+	t.Helper()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, prefix[:])
+
+	var out []byte
+	for counter := uint32(0); ; counter++ {
+		chunk := plain[:min(len(plain), chunkSize)]
+		plain = plain[len(chunk):]
+
+		last := len(plain) == 0
+		segmentNonce(nonce, counter, last)
+		out = gcm.Seal(out, nonce, chunk, ad)
+
+		if last {
+			return out
+		}
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 2500)
+	rand.Read(plain)
+
+	var prefix [streamNoncePrefixSize]byte
+	rand.Read(prefix[:])
+
+	v1 := append([]byte("HDWY\x01"), binary.BigEndian.AppendUint32(nil, 1000)...)
+	v1 = append(v1, prefix[:]...)
+	v1 = append(v1, sealSegments(t, key, prefix, 1000, plain, nil)...)
+
+	v2Header := Header{
+		Version:     2,
+		Cipher:      CipherAES256GCM,
+		KDF:         PBKDF2Params,
+		ChunkSize:   1000,
+		KeyID:       KeyID(key),
+		NoncePrefix: prefix,
+	}
+	v2 := append(v2Header.MarshalBinary(), sealSegments(t, key, prefix, 1000, plain, nil)...)
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	singleShot := gcm.Seal(nonce, nonce, plain, nil)
+
+	tests := []struct {
+		name      string
+		blob      []byte
+		version   uint8
+		chunkSize uint32
+		keyID     [8]byte
+	}{
+		{"single-shot", singleShot, 0, 0, [8]byte{}},
+		{"version 1", v1, 1, 1000, [8]byte{}},
+		{"version 2", v2, 2, 1000, KeyID(key)},
+		{"version 3", seal(t, plain, key, BlobContext("blob")), 3, StreamChunkSize, KeyID(key)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadHeader(bufio.NewReader(bytes.NewReader(tt.blob)))
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+
+			if h.Version != tt.version || h.ChunkSize != tt.chunkSize || h.KeyID != tt.keyID {
+				t.Errorf("got version %d, chunk size %d, key ID %x; want %d, %d, %x",
+					h.Version, h.ChunkSize, h.KeyID, tt.version, tt.chunkSize, tt.keyID)
+			}
+
+			if h.Version > 0 && h.NoncePrefix == [streamNoncePrefixSize]byte{} {
+				t.Error("nonce prefix not read")
+			}
+
+			var opened bytes.Buffer
+			if err := DecryptStream(&opened, bytes.NewReader(tt.blob), key, BlobContext("blob")); err != nil {
+				t.Fatalf("DecryptStream: %v", err)
+			}
+
+			if !bytes.Equal(opened.Bytes(), plain) {
+				t.Error("plaintext doesn't match")
+			}
+		})
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	valid := Header{
+		Version:   FormatVersion,
+		Cipher:    CipherAES256GCM,
+		KDF:       PBKDF2Params,
+		ChunkSize: StreamChunkSize,
+	}
+
+	with := func(change func(h *Header)) []byte {
+		h := valid
+		change(&h)
+		return h.MarshalBinary()
+	}
+
+	tests := []struct {
+		name    string
+		blob    []byte
+		wantErr error
+	}{
+		{"newer version", with(func(h *Header) { h.Version = FormatVersion + 1 }), ErrUnsupportedVersion},
+		{"unknown cipher", with(func(h *Header) { h.Cipher = 9 }), nil},
+		{"zero chunk size", with(func(h *Header) { h.ChunkSize = 0 }), nil},
+		{"huge chunk size", with(func(h *Header) { h.ChunkSize = maxChunkSize + 1 }), nil},
+		{"cut short", valid.MarshalBinary()[:headerV2Size-1], ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadHeader(bufio.NewReader(bytes.NewReader(tt.blob)))
+			if err == nil {
+				t.Fatal("no error")
+			}
+
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptStreamWrongKey(t *testing.T) {
+	sealed := seal(t, []byte("secret"), testKey(t), nil)
+
+	err := DecryptStream(&bytes.Buffer{}, bytes.NewReader(sealed), testKey(t), nil)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Errorf("got %v, want %v", err, ErrWrongKey)
+	}
+}
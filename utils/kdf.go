@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// DefaultArgon2Params is what new vaults start with (RFC 9106, second
+// recommended option). `hideaway kdf upgrade` tunes them for the machine.
+var DefaultArgon2Params = KDFParams{Algorithm: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+const maxArgon2Time = 64
+
+// MaxArgon2Memory is the most memory, in KiB, a vault may ask Argon2id for.
+const MaxArgon2Memory = 4 * 1024 * 1024
+
+// DeriveKEK derives the key that wraps the vault data key. Vaults that never
+// ran `kdf upgrade` still use PBKDF2 over the verifier salt.
+func DeriveKEK(password []byte, config Config) ([]byte, error) {
+	params := config.KeyDerivation()
+
+	switch params.Algorithm {
+	case KDFPBKDF2:
+		return DeriveKey(password, config.Salt), nil
+	case KDFArgon2id:
+		if err := validateArgon2(params); err != nil {
+			return nil, err
+		}
+
+		if len(config.KeySalt) < 16 {
+			return nil, fmt.Errorf("key salt too short")
+		}
+
+		return argon2.IDKey(password, config.KeySalt, params.Time, params.Memory, params.Threads, 32), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF %d", params.Algorithm)
+	}
+}
+
+func validateArgon2(params KDFParams) error {
+	if params.Time < 1 || params.Time > maxArgon2Time {
+		return fmt.Errorf("invalid argon2 time %d", params.Time)
+	}
+
+	if params.Threads < 1 {
+		return fmt.Errorf("invalid argon2 threads %d", params.Threads)
+	}
+
+	if params.Memory < 8*uint32(params.Threads) || params.Memory > MaxArgon2Memory {
+		return fmt.Errorf("invalid argon2 memory %d KiB", params.Memory)
+	}
+
+	return nil
+}
+
+// BenchmarkArgon2 raises the Argon2id time cost at the given memory (KiB)
+// until a single derivation on this machine takes at least target. It never
+// goes below DefaultArgon2Params, and rejects a memory or target that would
+// need it to.
+func BenchmarkArgon2(target time.Duration, memory uint32) (KDFParams, time.Duration, error) {
+	if memory < DefaultArgon2Params.Memory {
+		return KDFParams{}, 0, fmt.Errorf("memory %d MiB is below the default of %d MiB", memory/1024, DefaultArgon2Params.Memory/1024)
+	}
+
+	threads := uint8(min(runtime.NumCPU(), int(DefaultArgon2Params.Threads)))
+	params := KDFParams{Algorithm: KDFArgon2id, Time: DefaultArgon2Params.Time, Memory: memory, Threads: threads}
+
+	if err := validateArgon2(params); err != nil {
+		return KDFParams{}, 0, err
+	}
+
+	password := []byte("hideaway benchmark")
+	salt := make([]byte, 32)
+
+	for first := true; ; first = false {
+		start := time.Now()
+		argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, 32)
+		elapsed := time.Since(start)
+
+		if first && elapsed > target {
+			return KDFParams{}, 0, fmt.Errorf("target %s is below the %s the default parameters take, it would weaken the vault", target, elapsed.Round(time.Millisecond))
+		}
+
+		if elapsed >= target || params.Time >= maxArgon2Time {
+			return params, elapsed, nil
+		}
+
+		// Cost scales linearly with time, so jump close to the target instead
+		// of stepping one pass at a time.
+		next := uint32(float64(params.Time) * float64(target) / float64(elapsed))
+		if next <= params.Time {
+			next = params.Time + 1
+		}
+		params.Time = min(next, maxArgon2Time)
+	}
+}
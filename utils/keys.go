@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+/* KEY HIERARCHY
+- Every blob in `dump/` has its own random content key, wrapped by the vault
+  data key and stored in that file's record in `db.enc`.
+- The vault data key encrypts `db.enc` and is wrapped by a key derived from the
+  master password, stored in the config as `wrapped_key`.
+
+Changing the master password only re-wraps the data key, nothing in the vault
+is re-encrypted. Vaults created before the data key existed used the
+password-derived key directly, so on first unlock that key becomes the data key
+and the vault is flagged as legacy until `kdf upgrade` re-keys it.
+*/
+
+const vaultKeySize = 32
+
+// VaultKeyParams is recorded in the header of everything sealed with the
+// vault data key. It is random, so there is no KDF to describe.
+var VaultKeyParams = KDFParams{Algorithm: KDFNone}
+
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrInvalidVaultKey means a vault key isn't vaultKeySize bytes, usually
+// because the vault isn't unlocked. Wrapping such a key would lock everyone
+// out of the vault for good.
+var ErrInvalidVaultKey = errors.New("invalid vault key")
+
+func checkVaultKey(vaultKey []byte) error {
+	if len(vaultKey) != vaultKeySize {
+		return fmt.Errorf("%w: %d bytes, want %d", ErrInvalidVaultKey, len(vaultKey), vaultKeySize)
+	}
+	return nil
+}
+
+// UnlockVault verifies the master password and returns the vault data key.
+func UnlockVault(password []byte) ([]byte, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	kek, err := checkPassword(password, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var vaultKey []byte
+	if len(config.WrappedKey) == 0 {
+		if err := adoptLegacyKey(config, kek); err != nil {
+			return nil, err
+		}
+		vaultKey = kek
+	} else {
+		if vaultKey, err = Decrypt(config.WrappedKey, kek); err != nil {
+			return nil, fmt.Errorf("unwrapping vault key: %w", err)
+		}
+
+		if err := checkVaultKey(vaultKey); err != nil {
+			return nil, fmt.Errorf("unwrapping vault key: %w", err)
+		}
+
+		if err := finishUnlock(config, kek, vaultKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// Outside the lock, it may decrypt the whole vault.
+	backfill(vaultKey)
+
+	return vaultKey, nil
+}
+
+// adoptLegacyKey makes the password-derived key of a vault from before the
+// data key existed its data key, see KEY HIERARCHY.
+func adoptLegacyKey(config Config, kek []byte) error {
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := wrapVaultKey(&config, kek, kek); err != nil {
+		return err
+	}
+
+	config.LegacyVaultKey = true
+	setPasswordCheck(&config, kek)
+
+	if err := WriteConfig(config); err != nil {
+		return fmt.Errorf("saving wrapped key: %w", err)
+	}
+
+	return nil
+}
+
+// finishUnlock completes an interrupted re-key and replaces an old password
+// hash, under the vault lock.
+func finishUnlock(config Config, kek, vaultKey []byte) error {
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := finishRekey(vaultKey); err != nil {
+		return fmt.Errorf("finishing interrupted re-key: %w", err)
+	}
+
+	if len(config.PasswordCheck) == 0 {
+		if err := upgradePasswordCheck(config.WrappedKey, kek); err != nil {
+			color.Yellow("Could not replace the old password hash: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// checkPassword derives the key that wraps the vault key, or returns
+// ErrInvalidPassword when password is not the master password.
+func checkPassword(password []byte, config Config) ([]byte, error) {
+	if len(config.PasswordCheck) == 0 {
+		valid, err := VerifyPassword(password, config.HashedPassword, config.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("verifying password: %w", err)
+		}
+
+		if !valid {
+			return nil, ErrInvalidPassword
+		}
+
+		return DeriveKEK(password, config)
+	}
+
+	kek, err := DeriveKEK(password, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(passwordCheck(kek), config.PasswordCheck) {
+		return nil, ErrInvalidPassword
+	}
+
+	return kek, nil
+}
+
+// CheckPassword reports whether password is the master password.
+func CheckPassword(password []byte) (bool, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return false, fmt.Errorf("reading config: %w", err)
+	}
+
+	_, err = checkPassword(password, config)
+	if errors.Is(err, ErrInvalidPassword) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// passwordCheck is what the config keeps to recognize the master password. It
+// is derived from the KEK, so checking a guess against it costs as much as
+// the KDF in use, and `kdf upgrade` makes both harder.
+func passwordCheck(kek []byte) []byte {
+	mac := hmac.New(sha256.New, kek)
+	mac.Write([]byte("hideaway password check"))
+	return mac.Sum(nil)
+}
+
+func setPasswordCheck(config *Config, kek []byte) {
+	config.PasswordCheck = passwordCheck(kek)
+	config.HashedPassword = nil
+}
+
+// upgradePasswordCheck replaces the hash an older vault checks the password
+// with. It runs under the vault lock, and leaves the config alone when the
+// password changed since kek was derived.
+func upgradePasswordCheck(wrappedKey, kek []byte) error {
+	config, err := ReadConfig()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	if len(config.PasswordCheck) != 0 || !bytes.Equal(config.WrappedKey, wrappedKey) {
+		return nil
+	}
+
+	setPasswordCheck(&config, kek)
+	return WriteConfig(config)
+}
+
+// backfill brings records from older versions up to date. It only fills in
+// derived information, so failing is no reason to refuse the unlock.
+func backfill(vaultKey []byte) {
+	if err := backfillFileInfo(vaultKey); err != nil {
+		color.Yellow("Could not fill in file sizes: %v", err)
+	}
+}
+
+// VaultKeyMatches reports whether key is the one `db.enc` was sealed with, so a
+// key cached by the agent for an older vault is never used. An empty vault
+// matches any key.
+func VaultKeyMatches(key []byte) bool {
+	h, err := readFileHeader(dbFilePath())
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+
+	return h.Version < 2 || h.KeyID == KeyID(key)
+}
+
+// NewVaultConfig derives a password check from the master password and wraps a fresh random data key
+// under it, ready to be written by `hideaway init`.
+func NewVaultConfig(password []byte) (Config, error) {
+	vaultKey, err := GenerateSalt(vaultKeySize)
+	if err != nil {
+		return Config{}, fmt.Errorf("generating vault key: %w", err)
+	}
+
+	config := Config{KDF: DefaultArgon2Params}
+	if err := setPassword(&config, password, vaultKey); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// ChangePassword re-wraps the vault data key under a new master password.
+func ChangePassword(newPassword []byte, vaultKey []byte) error {
+	if err := checkVaultKey(vaultKey); err != nil {
+		return err
+	}
+
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := ReadConfig()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	if err := setPassword(&config, newPassword, vaultKey); err != nil {
+		return err
+	}
+
+	if err := WriteConfig(config); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	return nil
+}
+
+// UpgradeKDF switches the vault to new KDF parameters. The vault key is
+// re-wrapped, and a legacy password-derived vault key is replaced by a random
+// one, which re-encrypts the database and every blob.
+func UpgradeKDF(password []byte, vaultKey []byte, params KDFParams) error {
+	if err := checkVaultKey(vaultKey); err != nil {
+		return err
+	}
+
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := ReadConfig()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	config.KDF = params
+
+	if config.LegacyVaultKey {
+		newKey, err := GenerateSalt(vaultKeySize)
+		if err != nil {
+			return fmt.Errorf("generating vault key: %w", err)
+		}
+
+		if err := prepareRekey(vaultKey, newKey); err != nil {
+			return err
+		}
+
+		vaultKey = newKey
+		config.LegacyVaultKey = false
+	}
+
+	if err := setPassword(&config, password, vaultKey); err != nil {
+		return err
+	}
+
+	if err := WriteConfig(config); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	return finishRekey(vaultKey)
+}
+
+func setPassword(config *Config, password, vaultKey []byte) error {
+	salt, err := GenerateSalt(32)
+	if err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+
+	keySalt, err := GenerateSalt(32)
+	if err != nil {
+		return fmt.Errorf("generating key salt: %w", err)
+	}
+
+	config.Salt = salt
+	config.KeySalt = keySalt
+
+	kek, err := DeriveKEK(password, *config)
+	if err != nil {
+		return err
+	}
+
+	setPasswordCheck(config, kek)
+	return wrapVaultKey(config, vaultKey, kek)
+}
+
+func wrapVaultKey(config *Config, vaultKey, kek []byte) error {
+	if err := checkVaultKey(vaultKey); err != nil {
+		return err
+	}
+
+	wrapped, err := Encrypt(vaultKey, kek, config.KeyDerivation())
+	if err != nil {
+		return fmt.Errorf("wrapping vault key: %w", err)
+	}
+
+	config.WrappedKey = wrapped
+	return nil
+}
+
+// newContentKey returns a random per-file key and the same key wrapped by the
+// vault data key for storing in the file's record.
+func newContentKey(vaultKey []byte) ([]byte, []byte, error) {
+	contentKey, err := GenerateSalt(vaultKeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating content key: %w", err)
+	}
+
+	wrapped, err := Encrypt(contentKey, vaultKey, VaultKeyParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrapping content key: %w", err)
+	}
+
+	return contentKey, wrapped, nil
+}
+
+// ContentKey unwraps the key that sealed the blob. Files added before
+// per-file keys existed were sealed with the vault key itself.
+func (c Content) ContentKey(vaultKey []byte) ([]byte, error) {
+	if len(c.WrappedKey) == 0 {
+		return vaultKey, nil
+	}
+
+	contentKey, err := Decrypt(c.WrappedKey, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping content key: %w", err)
+	}
+
+	return contentKey, nil
+}
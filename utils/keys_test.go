@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// newUnlockedVault creates a vault with the master password "pw" holding one
+// file, and returns its vault key.
+func newUnlockedVault(t *testing.T) []byte {
+	t.Helper()
+
+	newTestVault(t)
+
+	config, err := NewVaultConfig([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	vaultKey, err := UnlockVault([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addTestFile(t, vaultKey, "a.txt", "secret")
+	return vaultKey
+}
+
+func TestRewrapRejectsInvalidVaultKey(t *testing.T) {
+	keys := []struct {
+		name string
+		key  []byte
+	}{
+		{"nil", nil},
+		{"empty", []byte{}},
+		{"too short", make([]byte, vaultKeySize/2)},
+		{"too long", make([]byte, vaultKeySize+1)},
+	}
+
+	changes := []struct {
+		name   string
+		change func(vaultKey []byte) error
+	}{
+		{"ChangePassword", func(vaultKey []byte) error { return ChangePassword([]byte("new"), vaultKey) }},
+		{"UpgradeKDF", func(vaultKey []byte) error { return UpgradeKDF([]byte("pw"), vaultKey, DefaultArgon2Params) }},
+	}
+
+	for _, change := range changes {
+		for _, key := range keys {
+			t.Run(change.name+" "+key.name, func(t *testing.T) {
+				vaultKey := newUnlockedVault(t)
+
+				before, err := os.ReadFile(configFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := change.change(key.key); !errors.Is(err, ErrInvalidVaultKey) {
+					t.Fatalf("got %v, want %v", err, ErrInvalidVaultKey)
+				}
+
+				after, err := os.ReadFile(configFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(before, after) {
+					t.Error("config changed")
+				}
+
+				unlocked, err := UnlockVault([]byte("pw"))
+				if err != nil {
+					t.Fatalf("old password no longer unlocks: %v", err)
+				}
+
+				if !bytes.Equal(unlocked, vaultKey) {
+					t.Error("vault key changed")
+				}
+
+				var got bytes.Buffer
+				if err := StreamFile("a.txt", unlocked, &got); err != nil || got.String() != "secret" {
+					t.Errorf("a.txt holds %q (%v), want %q", got.String(), err, "secret")
+				}
+			})
+		}
+	}
+}
+
+func TestUnlockVaultRejectsInvalidVaultKey(t *testing.T) {
+	newTestVault(t)
+
+	config := Config{KDF: DefaultArgon2Params, KeySalt: make([]byte, 32)}
+	kek, err := DeriveKEK([]byte("pw"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setPasswordCheck(&config, kek)
+
+	// What wrapVaultKey used to write when it was handed a nil key.
+	if config.WrappedKey, err = Encrypt(nil, kek, config.KeyDerivation()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnlockVault([]byte("pw")); !errors.Is(err, ErrInvalidVaultKey) {
+		t.Errorf("got %v, want %v", err, ErrInvalidVaultKey)
+	}
+}
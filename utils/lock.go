@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockTimeout is how long a command waits for another hideaway process to
+// finish changing the vault before giving up with ErrVaultBusy.
+var LockTimeout = 10 * time.Second
+
+var ErrVaultBusy = errors.New("vault busy, another hideaway process is changing it")
+
+// errLockHeld is returned by tryLockFile when someone else holds the lock.
+var errLockHeld = errors.New("lock held")
+
+// lockVault takes the advisory lock on `<userData>/vault.lock`. Every
+// read-modify-write of `db.enc` or the config happens while holding it, so
+// parallel processes can't overwrite each other's changes. It fails when the
+// vault was reset while waiting for the lock.
+func lockVault() (func(), error) {
+	unlock, err := lockFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(GetAppPaths()["userData"], "config.json")); err != nil {
+		unlock()
+		return nil, fmt.Errorf("vault was reset or never initialized: %w", err)
+	}
+
+	return unlock, nil
+}
+
+func lockFile() (func(), error) {
+	path := filepath.Join(GetAppPaths()["userData"], "vault.lock")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, errLockHeld) {
+			f.Close()
+			return nil, fmt.Errorf("locking vault: %w", err)
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, ErrVaultBusy
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// mutateStorage runs fn on the current contents of `db.enc` and saves the
+// result, all under the vault lock. A missing db starts out empty.
+func mutateStorage(vaultKey []byte, fn func(data *Storage) error) error {
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := readStorage(vaultKey)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := fn(&data); err != nil {
+		return err
+	}
+
+	return writeStorage(data, vaultKey)
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+/* METADATA
+- Tags, a note and key/value attributes the user attaches to a record. They
+  live in `db.enc` like everything else, so they are as private as the files.
+- Tags are matched without regard to case and kept sorted, the first spelling
+  used is the one stored.
+- They belong to the record, not its contents, so they survive new versions.
+*/
+
+// ValidateTag rejects tags that couldn't be told apart on the command line.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tags can't be empty")
+	}
+
+	if strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) {
+		return fmt.Errorf("tag %q can't contain spaces or commas", tag)
+	}
+
+	return nil
+}
+
+// HasTag reports whether the file is tagged with tag, ignoring case.
+func (f File) HasTag(tag string) bool {
+	return slices.ContainsFunc(f.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+}
+
+// TagFile adds tags to the file with the given ID or name.
+func TagFile(idOrName string, tags []string, vaultKey []byte) (File, error) {
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return File{}, err
+		}
+	}
+
+	return updateFile(idOrName, vaultKey, func(file *File) error {
+		for _, tag := range tags {
+			if !file.HasTag(tag) {
+				file.Tags = append(file.Tags, tag)
+			}
+		}
+
+		slices.SortFunc(file.Tags, func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		})
+		return nil
+	})
+}
+
+// UntagFile removes tags from the file with the given ID or name. Tags it
+// doesn't have are ignored.
+func UntagFile(idOrName string, tags []string, vaultKey []byte) (File, error) {
+	return updateFile(idOrName, vaultKey, func(file *File) error {
+		file.Tags = slices.DeleteFunc(file.Tags, func(t string) bool {
+			return slices.ContainsFunc(tags, func(tag string) bool { return strings.EqualFold(t, tag) })
+		})
+		if len(file.Tags) == 0 {
+			file.Tags = nil
+		}
+		return nil
+	})
+}
+
+// SetNote replaces the note of the file with the given ID or name, an empty
+// note removes it.
+func SetNote(idOrName, note string, vaultKey []byte) (File, error) {
+	return updateFile(idOrName, vaultKey, func(file *File) error {
+		file.Note = note
+		return nil
+	})
+}
+
+// SetAttributes sets attributes on the file with the given ID or name. An
+// empty value removes the attribute.
+func SetAttributes(idOrName string, attrs map[string]string, vaultKey []byte) (File, error) {
+	for key := range attrs {
+		if key == "" || strings.ContainsFunc(key, unicode.IsSpace) {
+			return File{}, fmt.Errorf("invalid attribute name %q", key)
+		}
+	}
+
+	return updateFile(idOrName, vaultKey, func(file *File) error {
+		for key, value := range attrs {
+			if value == "" {
+				delete(file.Attributes, key)
+				continue
+			}
+
+			if file.Attributes == nil {
+				file.Attributes = map[string]string{}
+			}
+			file.Attributes[key] = value
+		}
+
+		if len(file.Attributes) == 0 {
+			file.Attributes = nil
+		}
+		return nil
+	})
+}
+
+// updateFile applies fn to the record of the file with the given ID or name
+// and returns it as saved.
+func updateFile(idOrName string, vaultKey []byte, fn func(file *File) error) (File, error) {
+	var updated File
+
+	err := mutateStorage(vaultKey, func(data *Storage) error {
+		found, err := findFile(data.Files, idOrName)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(found); err != nil {
+			return err
+		}
+
+		updated = *found
+		return nil
+	})
+
+	return updated, err
+}
+
+// MetadataFilter selects files by their metadata. A file matches when it has
+// every tag, every attribute (an empty value only asks for the key) and a note
+// containing Note, ignoring case. The zero value matches everything.
+type MetadataFilter struct {
+	Tags       []string
+	Attributes map[string]string
+	Note       string
+}
+
+func (m MetadataFilter) Match(file File) bool {
+	for _, tag := range m.Tags {
+		if !file.HasTag(tag) {
+			return false
+		}
+	}
+
+	for key, value := range m.Attributes {
+		got, ok := file.Attributes[key]
+		if !ok || (value != "" && got != value) {
+			return false
+		}
+	}
+
+	return m.Note == "" || strings.Contains(strings.ToLower(file.Note), strings.ToLower(m.Note))
+}
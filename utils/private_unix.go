@@ -0,0 +1,32 @@
+//go:build unix
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivate makes sure only we can get into dir: a real directory, owned
+// by us, without any permissions for group or others.
+func checkPrivate(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %v)", dir, perm)
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+
+	return nil
+}
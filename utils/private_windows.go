@@ -0,0 +1,23 @@
+//go:build windows
+
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivate makes sure dir is a real directory. The temp directory on
+// Windows lives in the user's profile, which other users can't get into.
+func checkPrivate(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/* QUERIES
+A query is a list of terms separated by spaces, a file matches when every term
+does. A term is `field:value`, a bare word matches the name, and a leading `-`
+negates it. Values with spaces go in double quotes.
+
+  name:<glob>     the original name, e.g. name:*.pdf (bare words too)
+  ext:<ext>       the extension, with or without the dot
+  mime:<type>     the MIME type, a glob (image/*) or just its first half (image)
+  tag:<tag>       a tag
+  attr:<k>[=<v>]  an attribute, any value when v is left out
+  note:<text>     text in the note
+  added:<date>    when the file was added
+  modified:<date> when its current contents were stored
+  size:<size>     the size of its current contents
+
+Dates are 2006-01-02, 2006-01 or 2006 and sizes a number with an optional unit
+(B, KB, MB, GB, TB, all powers of 1024 like FormatSize). Both take a comparison
+(>, >=, <, <=, the default is =) or a range `a..b`. A date without a day or
+month covers all of it, so added:2025-03 is the whole of March.
+
+Everything but attributes is matched without regard to case.
+*/
+
+// Query is a parsed query, see ParseQuery. The zero value matches everything.
+type Query struct {
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	negate bool
+	match  func(File) bool
+}
+
+func (q Query) Match(file File) bool {
+	for _, term := range q.terms {
+		if term.match(file) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the files that match the query.
+func (q Query) Filter(files []File) []File {
+	var matched []File
+	for _, file := range files {
+		if q.Match(file) {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// ParseQuery parses a query like `ext:pdf tag:tax added:>2025-01-01 size:>10MB`.
+func ParseQuery(s string) (Query, error) {
+	words, err := splitQuery(s)
+	if err != nil {
+		return Query{}, err
+	}
+
+	var q Query
+	for _, word := range words {
+		term := queryTerm{}
+		if strings.HasPrefix(word, "-") && len(word) > 1 {
+			term.negate = true
+			word = word[1:]
+		}
+
+		field, value, ok := strings.Cut(word, ":")
+		if !ok {
+			field, value = "name", word
+		}
+
+		if value == "" {
+			return Query{}, fmt.Errorf("%s: needs a value", field)
+		}
+
+		term.match, err = parseTerm(strings.ToLower(field), value)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.terms = append(q.terms, term)
+	}
+
+	return q, nil
+}
+
+func parseTerm(field, value string) (func(File) bool, error) {
+	switch field {
+	case "name":
+		pattern := strings.ToLower(value)
+		if !strings.ContainsAny(pattern, "*?[") {
+			return func(f File) bool { return strings.Contains(strings.ToLower(f.OriginalName), pattern) }, nil
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("name:%s: %w", value, err)
+		}
+		return func(f File) bool {
+			ok, _ := filepath.Match(pattern, strings.ToLower(f.OriginalName))
+			return ok
+		}, nil
+
+	case "ext":
+		ext := "." + strings.TrimPrefix(strings.ToLower(value), ".")
+		return func(f File) bool {
+			return strings.EqualFold(f.Extension, ext) || strings.EqualFold(filepath.Ext(f.OriginalName), ext)
+		}, nil
+
+	case "mime", "type":
+		pattern := strings.ToLower(value)
+		if !strings.Contains(pattern, "/") {
+			pattern += "/*"
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("mime:%s: %w", value, err)
+		}
+		return func(f File) bool {
+			mime, _, _ := strings.Cut(strings.ToLower(f.MimeType), ";")
+			ok, _ := filepath.Match(pattern, strings.TrimSpace(mime))
+			return ok
+		}, nil
+
+	case "tag":
+		return func(f File) bool { return f.HasTag(value) }, nil
+
+	case "attr":
+		key, want, hasValue := strings.Cut(value, "=")
+		return func(f File) bool {
+			got, ok := f.Attributes[key]
+			return ok && (!hasValue || got == want)
+		}, nil
+
+	case "note":
+		text := strings.ToLower(value)
+		return func(f File) bool { return strings.Contains(strings.ToLower(f.Note), text) }, nil
+
+	case "added", "modified":
+		from, to, err := parseRange(value, parseDateRange)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", field, value, err)
+		}
+
+		date := func(f File) time.Time { return f.DateAdded }
+		if field == "modified" {
+			date = File.Modified
+		}
+
+		return func(f File) bool {
+			d := date(f).Unix()
+			return d >= from && d < to
+		}, nil
+
+	case "size":
+		from, to, err := parseRange(value, parseSizeRange)
+		if err != nil {
+			return nil, fmt.Errorf("size:%s: %w", value, err)
+		}
+
+		return func(f File) bool { return f.Size >= from && f.Size < to }, nil
+	}
+
+	return nil, fmt.Errorf("unknown field %q, use name, ext, mime, tag, attr, note, added, modified or size", field)
+}
+
+// parseRange turns a comparison or an `a..b` range into the half-open interval
+// [from, to). parse returns the interval a single value covers.
+func parseRange(value string, parse func(string) (int64, int64, error)) (int64, int64, error) {
+	const (
+		lowest  = int64(-1 << 63)
+		highest = int64(1<<63 - 1)
+	)
+
+	if a, b, ok := strings.Cut(value, ".."); ok {
+		if a == "" && b == "" {
+			return 0, 0, fmt.Errorf("a range needs a start, an end or both")
+		}
+
+		from, to := lowest, highest
+		if a != "" {
+			start, _, err := parse(a)
+			if err != nil {
+				return 0, 0, err
+			}
+			from = start
+		}
+		if b != "" {
+			_, end, err := parse(b)
+			if err != nil {
+				return 0, 0, err
+			}
+			to = end
+		}
+		return from, to, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		rest, ok := strings.CutPrefix(value, op)
+		if !ok {
+			continue
+		}
+
+		start, end, err := parse(rest)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		switch op {
+		case ">=":
+			return start, highest, nil
+		case "<=":
+			return lowest, end, nil
+		case ">":
+			return end, highest, nil
+		case "<":
+			return lowest, start, nil
+		}
+		return start, end, nil
+	}
+
+	return parse(value)
+}
+
+// parseDateRange returns the Unix seconds a date covers, in local time.
+func parseDateRange(s string) (int64, int64, error) {
+	layouts := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	}
+
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, s, time.Local); err == nil {
+			return t.Unix(), l.next(t).Unix(), nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("invalid date %q, use YYYY-MM-DD, YYYY-MM or YYYY", s)
+}
+
+// parseSizeRange returns the byte counts a size covers, just the one.
+func parseSizeRange(s string) (int64, int64, error) {
+	n, err := ParseSize(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, n + 1, nil
+}
+
+// ParseSize parses a size like 10MB or 1.5 GiB. Units are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i == -1 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	units := map[string]float64{
+		"": 1, "b": 1,
+		"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+		"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+		"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+		"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+	}
+
+	unit, ok := units[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, use B, KB, MB, GB or TB", s)
+	}
+
+	return int64(n * unit), nil
+}
+
+// splitQuery splits a query at spaces outside double quotes, and drops the
+// quotes.
+func splitQuery(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case unicode.IsSpace(r) && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in query")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
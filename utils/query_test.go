@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Add(time.Hour)
+	}
+
+	files := []File{
+		{
+			Id:           "1",
+			OriginalName: "Tax Return.PDF",
+			Extension:    ".pdf",
+			MimeType:     "application/pdf",
+			DateAdded:    day("2025-03-05"),
+			Tags:         []string{"tax"},
+			Content:      Content{Size: 20 << 20},
+		},
+		{
+			Id:           "2",
+			OriginalName: "cat.jpg",
+			Extension:    ".jpg",
+			MimeType:     "image/jpeg",
+			DateAdded:    day("2024-12-31"),
+			Attributes:   map[string]string{"who": "tom"},
+			Content:      Content{Size: 1024},
+		},
+		{
+			Id:           "3",
+			OriginalName: "notes.txt",
+			Extension:    ".txt",
+			MimeType:     "text/plain; charset=utf-8",
+			DateAdded:    day("2025-01-01"),
+			Note:         "Remember the milk",
+		},
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "123"},
+		{"ext:pdf tag:tax added:>2025-01-01 size:>10MB", "1"},
+		{"ext:.PDF", "1"},
+		{"name:*.pdf", "1"},
+		{"tax", "1"},
+		{`"tax return"`, "1"},
+		{"mime:image", "2"},
+		{"mime:text/plain", "3"},
+		{"-mime:image", "13"},
+		{"added:2025", "13"},
+		{"added:2025-01", "3"},
+		{"added:<2025-01-01", "2"},
+		{"added:<=2025-01-01", "23"},
+		{"added:2024-12-31..2025-01-01", "23"},
+		{"added:2025-01-02..", "1"},
+		{"size:1KB", "2"},
+		{"size:..1KB", "23"},
+		{"size:>=1K", "12"},
+		{"attr:who", "2"},
+		{"attr:who=bob", ""},
+		{"note:MILK", "3"},
+		{"-tag:tax -note:milk", "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+
+			got := ""
+			for _, file := range q.Filter(files) {
+				got += file.Id
+			}
+
+			if got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []string{
+		"foo:bar",
+		"tag:",
+		`"open`,
+		"name:[",
+		"mime:image/[",
+		"size:>",
+		"size:<=",
+		"size:>10XB",
+		"size:-1",
+		"size:..",
+		"added:..",
+		"added:yesterday",
+		"added:2025-13",
+		"added:..someday",
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			if _, err := ParseQuery(query); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+/* RE-KEY PROTOCOL
+- Re-wrap every content key under the new vault key. Blobs from before per-file
+  keys existed are re-encrypted to `dump/<id>.enc.rekey` with a fresh one.
+  Quarantined records only get their keys re-wrapped.
+- Write the db to `db.enc.rekey`
+- Write the config with the new wrapped key (the commit point)
+- Rename every blob `.rekey` file over its original, then the db last
+
+If we crash before the config is written, `db.enc.rekey` doesn't match the
+vault key and every `.rekey` file is thrown away on the next unlock. If we
+crash after, it does match and the renames are finished on the next unlock.
+*/
+
+const rekeySuffix = ".rekey"
+
+func prepareRekey(oldKey, newKey []byte) error {
+	data, err := readStorage(oldKey)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading db: %w", err)
+	}
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	// Blobs shared by several records or versions are re-encrypted once.
+	reencrypted := map[string]Content{}
+
+	for i := range data.Files {
+		file := &data.Files[i]
+
+		for _, c := range file.contents() {
+			// The MAC key comes from the vault key, backfill recomputes them
+			// on the next unlock.
+			c.ContentMAC = ""
+
+			contentKey, err := c.ContentKey(oldKey)
+			if err != nil {
+				return fmt.Errorf("re-keying %s: %w", file.OriginalName, err)
+			}
+
+			if len(c.WrappedKey) > 0 {
+				c.WrappedKey, err = Encrypt(contentKey, newKey, VaultKeyParams)
+				if err != nil {
+					return fmt.Errorf("re-wrapping %s: %w", file.OriginalName, err)
+				}
+				continue
+			}
+
+			blob := file.blobOf(*c)
+			if done, ok := reencrypted[blob]; ok {
+				c.WrappedKey = done.WrappedKey
+				c.CipherSize = done.CipherSize
+				continue
+			}
+
+			var fileKey []byte
+			fileKey, c.WrappedKey, err = newContentKey(newKey)
+			if err != nil {
+				return err
+			}
+
+			path := blobPath(blob)
+			if err := reencryptFile(path, path+rekeySuffix, blob, contentKey, fileKey); err != nil {
+				return fmt.Errorf("re-encrypting %s: %w", file.OriginalName, err)
+			}
+
+			if info, err := os.Stat(path + rekeySuffix); err == nil {
+				c.CipherSize = info.Size()
+			}
+
+			reencrypted[blob] = *c
+		}
+	}
+
+	// Quarantined blobs are left as they are, most of them won't decrypt
+	// anyway. Their keys are re-wrapped so they can still be recovered, and
+	// the old vault key becomes the content key of those it sealed itself.
+	var kept []File
+	for i := range data.Quarantine {
+		file := &data.Quarantine[i]
+		if err := rewrapContents(file, oldKey, newKey); err != nil {
+			color.Yellow("Dropping quarantined %s, its key could not be re-wrapped: %v", file.OriginalName, err)
+			continue
+		}
+		kept = append(kept, *file)
+	}
+	data.Quarantine = kept
+
+	marshaled, err := marshalStorage(data)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := Encrypt(marshaled, newKey, VaultKeyParams)
+	if err != nil {
+		return fmt.Errorf("encrypting db: %w", err)
+	}
+
+	if err := WriteFileAtomic(dbFilePath()+rekeySuffix, encrypted, 0644); err != nil {
+		return fmt.Errorf("writing db: %w", err)
+	}
+
+	return nil
+}
+
+func rewrapContents(file *File, oldKey, newKey []byte) error {
+	for _, c := range file.contents() {
+		c.ContentMAC = ""
+
+		contentKey, err := c.ContentKey(oldKey)
+		if err != nil {
+			return err
+		}
+
+		if c.WrappedKey, err = Encrypt(contentKey, newKey, VaultKeyParams); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func reencryptFile(inputPath, outputPath, blobId string, oldKey, newKey []byte) error {
+	src, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := CreateAtomic(outputPath, 0644)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(DecryptStream(pw, src, oldKey, BlobContext(blobId)))
+	}()
+
+	err = EncryptStream(dst, pr, newKey, VaultKeyParams, BlobContext(blobId))
+	pr.CloseWithError(err)
+
+	if err != nil {
+		dst.Abort()
+		return err
+	}
+
+	return dst.Commit()
+}
+
+// finishRekey completes or discards the `.rekey` files left by an upgrade,
+// depending on whether `db.enc.rekey` was sealed with the current vault key.
+func finishRekey(vaultKey []byte) error {
+	pendingDB := dbFilePath() + rekeySuffix
+
+	pending, err := filepath.Glob(filepath.Join(dumpDir(), "*"+rekeySuffix))
+	if err != nil {
+		return err
+	}
+
+	h, err := readFileHeader(pendingDB)
+	committed := err == nil && h.KeyID == KeyID(vaultKey)
+
+	if committed {
+		pending = append(pending, pendingDB)
+	} else {
+		os.Remove(pendingDB)
+	}
+
+	for _, path := range pending {
+		if !committed {
+			os.Remove(path)
+			continue
+		}
+
+		if err := renameDurable(path, strings.TrimSuffix(path, rekeySuffix)); err != nil {
+			return err
+		}
+	}
+
+	// The backup is sealed with the old key, which may be the weak legacy one.
+	if committed {
+		os.Remove(dbBackupPath())
+	}
+
+	return nil
+}
+
+func readFileHeader(path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+
+	return ReadHeader(bufio.NewReader(f))
+}
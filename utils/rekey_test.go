@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestVault points the app paths at a temporary directory and creates an
+// empty vault there.
+func newTestVault(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	if err := os.MkdirAll(dumpDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// addTestFile encrypts contents into the vault under name and returns its
+// record.
+func addTestFile(t *testing.T, vaultKey []byte, name, contents string) File {
+	t.Helper()
+
+	file, err := EncryptReader(bytes.NewReader([]byte(contents)), dumpDir(), name, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := AppendFiles([]File{file}, vaultKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return added[0].File
+}
+
+func pendingRekeyFiles(t *testing.T) []string {
+	t.Helper()
+
+	pending, err := filepath.Glob(filepath.Join(dumpDir(), "*"+rekeySuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(dbFilePath() + rekeySuffix); err == nil {
+		pending = append(pending, dbFilePath()+rekeySuffix)
+	}
+
+	return pending
+}
+
+func TestFinishRekey(t *testing.T) {
+	tests := []struct {
+		name string
+		// committed is whether the crash came after the config was written.
+		committed bool
+		// renamed is how many blob renames were done before the crash.
+		renamed int
+	}{
+		{"crash before the config", false, 0},
+		{"crash after the config", true, 0},
+		{"crash during the renames", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestVault(t)
+			oldKey, newKey := testKey(t), testKey(t)
+
+			wrapped := addTestFile(t, oldKey, "wrapped.txt", "per-file key")
+
+			// A blob from before per-file keys, sealed with the vault key
+			// itself, which the re-key has to re-encrypt.
+			legacy := File{Id: "legacy", OriginalName: "legacy.txt"}
+			out, err := os.Create(blobPath(legacy.Id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			EncryptStream(out, bytes.NewReader([]byte("vault key")), oldKey, PBKDF2Params, BlobContext(legacy.Id))
+			out.Close()
+
+			if _, err := AppendFiles([]File{legacy}, oldKey, false); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := prepareRekey(oldKey, newKey); err != nil {
+				t.Fatalf("prepareRekey: %v", err)
+			}
+
+			if pending := pendingRekeyFiles(t); len(pending) != 2 {
+				t.Fatalf("got %d .rekey files, want the db and the legacy blob: %v", len(pending), pending)
+			}
+
+			if tt.renamed > 0 {
+				if err := renameDurable(blobPath(legacy.Id)+rekeySuffix, blobPath(legacy.Id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			key := oldKey
+			if tt.committed {
+				key = newKey
+			}
+
+			if err := finishRekey(key); err != nil {
+				t.Fatalf("finishRekey: %v", err)
+			}
+
+			if pending := pendingRekeyFiles(t); len(pending) > 0 {
+				t.Errorf(".rekey files left behind: %v", pending)
+			}
+
+			want := map[string]string{wrapped.Id: "per-file key", legacy.Id: "vault key"}
+			for id, contents := range want {
+				var got bytes.Buffer
+				if err := StreamFile(id, key, &got); err != nil {
+					t.Fatalf("reading %s: %v", id, err)
+				}
+
+				if got.String() != contents {
+					t.Errorf("%s holds %q, want %q", id, got.String(), contents)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy decides what happens when a retrieved file would land on
+// something that already exists.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictRename    ConflictPolicy = "rename"
+)
+
+var ErrSkipped = errors.New("already exists, skipped")
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q, use skip, overwrite or rename", s)
+	}
+}
+
+// RetrieveOptions say where a retrieved file or collection goes. Output is the
+// exact path to write to, OutputDir a directory to write into under the
+// original name. Without either it goes back to where it was added from, or to
+// the fallback directory if that's gone. An empty OnConflict uses the one from
+// the config.
+type RetrieveOptions struct {
+	Output     string
+	OutputDir  string
+	OnConflict ConflictPolicy
+}
+
+// FallbackDir is where retrieved files go when the place they were added from
+// no longer exists: the configured `retrieve_dir`, otherwise the Desktop or,
+// on machines without one, the home directory.
+func FallbackDir() string {
+	if config, err := ReadConfig(); err == nil && config.RetrieveDir != "" {
+		return config.RetrieveDir
+	}
+
+	paths := GetAppPaths()
+	if info, err := os.Stat(paths["desktop"]); err == nil && info.IsDir() {
+		return paths["desktop"]
+	}
+
+	return paths["home"]
+}
+
+func (o RetrieveOptions) conflictPolicy() ConflictPolicy {
+	if o.OnConflict != "" {
+		return o.OnConflict
+	}
+
+	if config, err := ReadConfig(); err == nil && config.OnConflict != "" {
+		return config.OnConflict
+	}
+
+	return ConflictRename
+}
+
+// target works out where something named name, added from originalPath, is
+// written to.
+func (o RetrieveOptions) target(name, originalPath string) (string, error) {
+	if o.Output != "" {
+		// An existing directory means "put it in here".
+		if info, err := os.Stat(o.Output); err == nil && info.IsDir() && o.OutputDir == "" {
+			return filepath.Join(o.Output, name), nil
+		}
+		return o.Output, nil
+	}
+
+	if o.OutputDir != "" {
+		return filepath.Join(o.OutputDir, name), nil
+	}
+
+	dir := filepath.Dir(originalPath)
+	if _, err := os.Stat(dir); originalPath == "" || err != nil {
+		dir = FallbackDir()
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// resolveConflict applies the conflict policy to path. It returns the path to
+// write to, or ErrSkipped.
+func resolveConflict(path string, policy ConflictPolicy) (string, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		return path, nil
+	case ConflictSkip:
+		return "", fmt.Errorf("%s %w", path, ErrSkipped)
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+}
+
+// StreamFile decrypts the file with the given ID or name to w. Segments are
+// authenticated before they are written, so w never sees unverified data, but
+// it may get the start of a file whose blob turns out to be damaged later on.
+func StreamFile(fileId string, vaultKey []byte, w io.Writer) error {
+	data, err := readStorage(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	found, err := findFile(data.Files, fileId)
+	if err != nil {
+		return err
+	}
+
+	contentKey, err := found.ContentKey(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(blobPath(found.Blob()))
+	if err != nil {
+		return fmt.Errorf("reading encrypted file: %w", err)
+	}
+	defer src.Close()
+
+	err = DecryptStream(w, src, contentKey, BlobContext(found.Blob()))
+	if errors.Is(err, ErrWrongKey) {
+		err = fmt.Errorf("%w: %w", ErrTampered, err)
+	}
+
+	return err
+}
+
+// findFile looks a file up by its ID, or by its name when that is unique.
+func findFile(files []File, idOrName string) (*File, error) {
+	for i := range files {
+		if files[i].Id == idOrName {
+			return &files[i], nil
+		}
+	}
+
+	var matches []string
+	var found *File
+	for i := range files {
+		if files[i].OriginalName == idOrName {
+			matches = append(matches, files[i].Id)
+			found = &files[i]
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("file %s %w", idOrName, ErrNotFound)
+	case 1:
+		return found, nil
+	default:
+		return nil, fmt.Errorf("%d files are named %s, use one of their ids: %s", len(matches), idOrName, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Setting is a preference stored in the config that `hideaway config` can
+// show and change. Setting a value to "" restores the default.
+type Setting struct {
+	Key         string
+	Description string
+	Get         func(Config) string
+	Set         func(*Config, string) error
+}
+
+var Settings = []Setting{
+	{
+		Key:         "retrieve-dir",
+		Description: "Where retrieved files go when the directory they were added from is gone (default: Desktop, or home without one)",
+		Get:         func(c Config) string { return c.RetrieveDir },
+		Set: func(c *Config, value string) error {
+			if value == "" {
+				c.RetrieveDir = ""
+				return nil
+			}
+
+			if value == "~" || strings.HasPrefix(value, "~/") {
+				value = filepath.Join(GetAppPaths()["home"], value[1:])
+			}
+
+			dir, err := filepath.Abs(value)
+			if err != nil {
+				return err
+			}
+
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+
+			c.RetrieveDir = dir
+			return nil
+		},
+	},
+	{
+		Key:         "on-conflict",
+		Description: "What get does when the file it retrieves already exists: skip, overwrite or rename (default: rename)",
+		Get:         func(c Config) string { return string(c.OnConflict) },
+		Set: func(c *Config, value string) error {
+			if value == "" {
+				c.OnConflict = ""
+				return nil
+			}
+
+			policy, err := ParseConflictPolicy(value)
+			if err != nil {
+				return err
+			}
+
+			c.OnConflict = policy
+			return nil
+		},
+	},
+	{
+		Key:         "keep-versions",
+		Description: "How many old versions of each file to keep (default: 0, all of them)",
+		Get:         func(c Config) string { return formatLimit(c.KeepVersions) },
+		Set: func(c *Config, value string) (err error) {
+			c.KeepVersions, err = intSetting(value)
+			return err
+		},
+	},
+	{
+		Key:         "keep-versions-days",
+		Description: "How many days to keep old versions after they were replaced (default: 0, forever)",
+		Get:         func(c Config) string { return formatLimit(c.KeepVersionsDays) },
+		Set: func(c *Config, value string) (err error) {
+			c.KeepVersionsDays, err = intSetting(value)
+			return err
+		},
+	},
+	{
+		Key:         "list-columns",
+		Description: "The columns list shows, comma separated, from " + strings.Join(columnKeys(), ", ") + " (default: " + strings.Join(DefaultColumns, ",") + ")",
+		Get:         func(c Config) string { return strings.Join(c.ListColumnKeys, ",") },
+		Set: func(c *Config, value string) error {
+			if value == "" {
+				c.ListColumnKeys = nil
+				return nil
+			}
+
+			columns, err := ParseColumns(value)
+			if err != nil {
+				return err
+			}
+
+			c.ListColumnKeys = nil
+			for _, column := range columns {
+				c.ListColumnKeys = append(c.ListColumnKeys, column.Key)
+			}
+			return nil
+		},
+	},
+	{
+		Key:         "list-sort",
+		Description: "How list sorts files: name, date, size or type, with -desc to reverse it (default: date)",
+		Get:         func(c Config) string { return c.ListSort },
+		Set: func(c *Config, value string) error {
+			if value == "" {
+				c.ListSort = ""
+				return nil
+			}
+
+			order, err := ParseSortOrder(value)
+			if err != nil {
+				return err
+			}
+
+			c.ListSort = order.String()
+			return nil
+		},
+	},
+}
+
+func formatLimit(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func intSetting(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a number, use 0 for no limit", value)
+	}
+
+	return n, nil
+}
+
+func FindSetting(key string) (Setting, error) {
+	for _, s := range Settings {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+
+	return Setting{}, fmt.Errorf("unknown setting %q", key)
+}
+
+// SetSetting changes one setting and saves the config.
+func SetSetting(key, value string) error {
+	setting, err := FindSetting(key)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockVault()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := ReadConfig()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	if err := setting.Set(&config, value); err != nil {
+		return err
+	}
+
+	if err := WriteConfig(config); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	return nil
+}
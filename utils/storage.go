@@ -11,6 +11,9 @@ import (
 	"github.com/fatih/color"
 )
 
+// File is the record of one stored file. Its current contents are in the
+// embedded Content, older ones in Versions (see versions.go). Tags, Note and
+// Attributes are the user's own metadata (see metadata.go).
 type File struct {
 	Id           string    `json:"id"`
 	OriginalName string    `json:"original_name"`
@@ -18,294 +21,342 @@ type File struct {
 	DateAdded    time.Time `json:"date_added"`
 	MimeType     string    `json:"mime_type"`
 	Extension    string    `json:"extension"`
-	Size         int64     `json:"file_size"`
+	CollectionId string    `json:"collection_id,omitempty"`
+	RelativePath string    `json:"relative_path,omitempty"`
+
+	Tags       []string          `json:"tags,omitempty"`
+	Note       string            `json:"note,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	Content
+	// DateModified is when the current contents were stored, zero if they
+	// are the ones the file was added with.
+	DateModified time.Time `json:"date_modified,omitempty"`
+	Version      int       `json:"version,omitempty"`
+	Versions     []Version `json:"versions,omitempty"`
+}
+
+// Content describes one blob's worth of a file. Size and SHA256 describe the
+// plaintext, CipherSize the blob. ContentMAC finds files with equal contents
+// (see dedup.go), and BlobId names the blob when it's shared with an earlier
+// file, empty means the blob is named after the file's Id.
+type Content struct {
+	Size       int64  `json:"file_size"`
+	CipherSize int64  `json:"cipher_size"`
+	SHA256     string `json:"sha256,omitempty"`
+	ContentMAC string `json:"content_mac,omitempty"`
+	BlobId     string `json:"blob_id,omitempty"`
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
 }
 
 type Storage struct {
-	Files []File `json:"files"`
+	Files       []File       `json:"files"`
+	Collections []Collection `json:"collections,omitempty"`
+
+	// RefCounts is the number of records using each blob in `dump/`.
+	RefCounts map[string]int `json:"ref_counts,omitempty"`
+
+	// Quarantine holds the records of files whose blob failed verification,
+	// their blobs are moved to `<userData>/quarantine`.
+	Quarantine []File `json:"quarantine,omitempty"`
 }
 
 /* STORAGE PROTOCOL
 - Decrypt
 - Add or remove.
 - Encrypt
-- Save to `db.enc`
+- Save to a temp file, fsync, move `db.enc` to `db.enc.bak`, rename the temp
+  file to `db.enc`, fsync the directory
 
 We only decrypt to memory -> decrypt -> use Json.Marshal
 Move files to a `<appPath>/files/<encrypted-version>.enc`
 
 */
 
-func AppendFile(file File, masterPassword []byte) error {
-	config, err := ReadConfig()
+func dbFilePath() string {
+	paths := GetAppPaths()
+	return filepath.Join(paths["userData"], "db.enc")
+}
+
+func dumpDir() string {
+	paths := GetAppPaths()
+	return filepath.Join(paths["userData"], "dump")
+}
+
+func blobPath(id string) string {
+	return filepath.Join(dumpDir(), id+".enc")
+}
+
+// readStorage loads and decrypts `db.enc`. The header is parsed first, so a
+// database written by a newer, unknown format version fails with a clear error.
+// If the primary can't be read, the last good copy in `db.enc.bak` is used.
+func readStorage(vaultKey []byte) (Storage, error) {
+	jsonData, err := readStorageFile(dbFilePath(), vaultKey)
+	if err == nil {
+		return jsonData, nil
+	}
+
+	backup, backupErr := readStorageFile(dbBackupPath(), vaultKey)
+	if backupErr != nil {
+		return Storage{}, err
+	}
+
+	if !os.IsNotExist(err) {
+		color.Yellow("db.enc could not be read (%v), using db.enc.bak instead", err)
+	}
 
+	return backup, nil
+}
+
+func readStorageFile(path string, vaultKey []byte) (Storage, error) {
+	encryptedData, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("Could not read config")
-		return err
+		return Storage{}, err
 	}
 
-	paths := GetAppPaths()
-	dbPath := filepath.Join(paths["userData"], "db.enc")
-
-	derivedKey := DeriveKey(masterPassword, config.Salt)
-
-	newFileEntry := File{
-		Id:           file.Id,
-		OriginalName: file.OriginalName,
-		OriginalPath: file.OriginalPath,
-		DateAdded:    file.DateAdded,
-		MimeType:     file.MimeType,
-		Extension:    file.Extension,
-		Size:         file.Size,
+	decrypted, err := Decrypt(encryptedData, vaultKey)
+	if err != nil {
+		return Storage{}, fmt.Errorf("decrypting db: %w", err)
 	}
 
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		data := Storage{
-			Files: []File{
-				newFileEntry,
-			},
-		}
+	var jsonData Storage
+	if err := json.Unmarshal(decrypted, &jsonData); err != nil {
+		return Storage{}, fmt.Errorf("reading JSON data: %w", err)
+	}
 
-		jsonData, err := json.MarshalIndent(data, "", " ")
+	return jsonData, nil
+}
 
-		if err != nil {
-			fmt.Printf("Error marshaling JSON: %s", err)
-			return err
-		}
+func dbBackupPath() string {
+	return dbFilePath() + ".bak"
+}
 
-		encrypted, err := Encrypt(jsonData, derivedKey)
+func marshalStorage(data Storage) ([]byte, error) {
+	marshaledData, err := json.MarshalIndent(data, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling JSON data: %w", err)
+	}
 
-		if err != nil {
-			fmt.Printf("Error while encrypting new settings: %s", err)
-			return err
-		}
+	return marshaledData, nil
+}
 
-		os.WriteFile(dbPath, encrypted, 0644)
+func writeStorage(data Storage, vaultKey []byte) error {
+	marshaledData, err := marshalStorage(data)
+	if err != nil {
+		return err
+	}
 
-		color.Cyan("Successfully added file to vault")
-	} else {
-		encryptedData, err := os.ReadFile(dbPath)
+	encrypted, err := Encrypt(marshaledData, vaultKey, VaultKeyParams)
+	if err != nil {
+		return fmt.Errorf("encrypting db: %w", err)
+	}
 
-		if err != nil {
-			fmt.Printf("Something went wrong while decoding db: %s", err)
-			return err
-		}
+	f, err := CreateAtomic(dbFilePath(), 0644)
+	if err != nil {
+		return fmt.Errorf("writing db: %w", err)
+	}
 
-		decrypted, err := Decrypt(encryptedData, derivedKey)
+	if _, err := f.Write(encrypted); err != nil {
+		f.Abort()
+		return fmt.Errorf("writing db: %w", err)
+	}
 
-		if err != nil {
-			fmt.Printf("Something went wrong while decrypting file data")
-			return err
+	// Only a db that still opens is worth keeping as the backup, otherwise
+	// we'd replace the last good copy with a broken one.
+	if _, err := readStorageFile(dbFilePath(), vaultKey); err == nil {
+		if err := renameDurable(dbFilePath(), dbBackupPath()); err != nil {
+			f.Abort()
+			return fmt.Errorf("rotating db backup: %w", err)
 		}
+	}
 
-		var jsonData Storage
-		err = json.Unmarshal(decrypted, &jsonData)
+	if err := f.Commit(); err != nil {
+		return fmt.Errorf("writing db: %w", err)
+	}
 
-		if err != nil {
-			fmt.Printf("Something went wrong while reading JSON data: %s", err)
-			return err
-		}
+	return nil
+}
 
-		jsonData.Files = append(jsonData.Files, newFileEntry)
-		marshaledData, err := json.MarshalIndent(jsonData, "", " ")
+func AppendFile(file File, vaultKey []byte) error {
+	isNew := false
 
-		if err != nil {
-			fmt.Printf("Something went wrong while marshaling JSON data: %s", err)
-			return err
-		}
-		encrypted, err := Encrypt(marshaledData, derivedKey)
+	var redundant []string
 
-		if err != nil {
-			fmt.Printf("Something went wrong while re-encrypting data: %s", err)
-			return err
-		}
+	err := mutateStorage(vaultKey, func(jsonData *Storage) error {
+		isNew = len(jsonData.Files) == 0
+		_, redundant = jsonData.addFiles([]File{file}, false, RetentionPolicy{})
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("Something went wrong while saving db: %s", err)
+		return err
+	}
 
-		os.WriteFile(dbPath, encrypted, 0644)
+	removeBlobs(redundant)
 
+	if isNew {
+		color.Cyan("Successfully added file to vault")
+	} else {
 		color.Cyan("Successfully inserted file to vault")
 	}
 
 	return nil
 }
 
-func GetVaultContent(masterPassword []byte) ([]File, error) {
-	paths := GetAppPaths()
-	dbPath := filepath.Join(paths["userData"], "db.enc")
-
+// AppendFiles records several files with a single write of `db.enc` and
+// reports what became of each. With asVersions, files that are already in the
+// vault get a new version instead of a second record.
+func AppendFiles(files []File, vaultKey []byte, asVersions bool) ([]AddResult, error) {
 	config, err := ReadConfig()
-
 	if err != nil {
-		color.Yellow("Something went wrong while reading the config file")
-		return []File{}, err
+		return nil, fmt.Errorf("reading config: %w", err)
 	}
 
-	derivedKey := DeriveKey(masterPassword, config.Salt)
+	var added []AddResult
+	var redundant []string
 
-	encryptedData, err := os.ReadFile(dbPath)
+	err = mutateStorage(vaultKey, func(jsonData *Storage) error {
+		added, redundant = jsonData.addFiles(files, asVersions, config.Retention())
+		return nil
+	})
 
 	if err != nil {
-		fmt.Printf("Something went wrong while decoding db: %s", err)
-		return []File{}, err
+		return nil, err
 	}
 
-	decrypted, err := Decrypt(encryptedData, derivedKey)
+	removeBlobs(redundant)
 
-	var jsonData Storage
-	err = json.Unmarshal(decrypted, &jsonData)
+	return added, nil
+}
+
+func GetVaultContent(vaultKey []byte) ([]File, error) {
+	jsonData, err := readStorage(vaultKey)
 
 	if err != nil {
-		fmt.Printf("Something went wrong while getting JSON data: %s", err)
-		return []File{}, nil
+		fmt.Printf("Something went wrong while reading db: %s", err)
+		return []File{}, err
 	}
 
 	return jsonData.Files, nil
 }
 
-func RetrieveFile(fileId string, masterPassword []byte) error {
+// RetrieveFile decrypts the file with the given ID or name to the place opts
+// point at, and returns the path it was written to.
+func RetrieveFile(fileId string, vaultKey []byte, opts RetrieveOptions) (string, error) {
 	paths := GetAppPaths()
-	dbPath := filepath.Join(paths["userData"], "db.enc")
 	dumpPath := filepath.Join(paths["userData"], "dump")
-	desktopPath := paths["desktop"]
 
-	config, err := ReadConfig()
+	jsonData, err := readStorage(vaultKey)
 
 	if err != nil {
-		color.Yellow("Something went wrong while reading the config file")
-		return err
+		fmt.Printf("Something went wrong while reading db: %s", err)
+		return "", err
 	}
 
-	derivedKey := DeriveKey(masterPassword, config.Salt)
-
-	encryptedData, err := os.ReadFile(dbPath)
-
+	found, err := findFile(jsonData.Files, fileId)
 	if err != nil {
-		fmt.Printf("Something went wrong while decoding db: %s", err)
-		return err
+		return "", err
 	}
 
-	decrypted, err := Decrypt(encryptedData, derivedKey)
-
-	var jsonData Storage
-	err = json.Unmarshal(decrypted, &jsonData)
+	fullFilePath := fmt.Sprintf("%s/%s.enc", dumpPath, found.Blob())
 
+	contentKey, err := found.ContentKey(vaultKey)
 	if err != nil {
-		fmt.Printf("Something went wrong while getting JSON data: %s", err)
-		return err
+		return "", err
 	}
 
-	var found *File
-	files := jsonData.Files
+	target, err := opts.target(found.OriginalName, found.OriginalPath)
+	if err != nil {
+		return "", err
+	}
 
-	for i := range files {
-		if files[i].Id == fileId {
-			found = &files[i]
-			break
-		}
+	target, err = resolveConflict(target, opts.conflictPolicy())
+	if err != nil {
+		return "", err
 	}
 
-	if found == nil {
-		fmt.Println("file not found")
-		return fmt.Errorf("file with id %s not found", fileId)
+	if err := DecryptFile(fullFilePath, target, contentKey, found.Blob()); err != nil {
+		return "", fmt.Errorf("failed to decrypt file to %s: %w", target, err)
 	}
 
-	fullFilePath := fmt.Sprintf("%s/%s.enc", dumpPath, found.Id)
+	return target, nil
+}
 
-	originalDir := filepath.Dir(found.OriginalPath)
+func DeleteFile(idOrName string, vaultKey []byte) ([]File, error) {
+	paths := GetAppPaths()
+	dumpPath := filepath.Join(paths["userData"], "dump")
 
-	if _, err := os.Stat(originalDir); os.IsNotExist(err) {
-		desktopFilePath := filepath.Join(desktopPath, found.OriginalName)
-		err = DecryptFile(fullFilePath, desktopFilePath, string(masterPassword))
+	var unused []string
+	var remaining []File
 
+	err := mutateStorage(vaultKey, func(jsonData *Storage) error {
+		found, err := findFile(jsonData.Files, idOrName)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt file to desktop: %w", err)
+			return err
 		}
+		foundIndex := slices.IndexFunc(jsonData.Files, func(f File) bool { return f.Id == found.Id })
 
-		// fmt.Printf("ENCRYPTED FILE: %s\nDECRYPTED TO DESKTOP: %s\n", fullFilePath, desktopFilePath)
-	} else {
-		originalFilePath := filepath.Join(originalDir, found.OriginalName)
-		err = DecryptFile(fullFilePath, originalFilePath, string(masterPassword))
+		collectionId := jsonData.Files[foundIndex].CollectionId
 
-		if err != nil {
-			return fmt.Errorf("failed to decrypt file to original location: %w", err)
+		// The blobs, old versions' included, may hold the contents of other
+		// files too.
+		for _, blob := range jsonData.Files[foundIndex].blobs() {
+			if jsonData.unref(blob) {
+				unused = append(unused, blob)
+			}
 		}
 
-		// fmt.Printf("ENCRYPTED FILE: %s\nDECRYPTED TO: %s\n", fullFilePath, originalFilePath)
-	}
-
-	fmt.Printf("Decryption done.")
-
-	return nil
-}
+		jsonData.Files = slices.Delete(jsonData.Files, foundIndex, foundIndex+1)
+		remaining = jsonData.Files
 
-func DeleteFile(fileId string, masterPassword []byte) ([]File, error) {
-	paths := GetAppPaths()
-	dbPath := filepath.Join(paths["userData"], "db.enc")
-	dumpPath := filepath.Join(paths["userData"], "dump")
-
-	config, err := ReadConfig()
-	if err != nil {
-		color.Yellow("Something went wrong while reading the config file")
-		return nil, err
-	}
-
-	derivedKey := DeriveKey(masterPassword, config.Salt)
-
-	encryptedData, err := os.ReadFile(dbPath)
-	if err != nil {
-		fmt.Printf("Something went wrong while decoding db: %s", err)
-		return nil, err
-	}
+		// Forget the collection once its last file is gone.
+		if collectionId != "" && !slices.ContainsFunc(jsonData.Files, func(f File) bool { return f.CollectionId == collectionId }) {
+			jsonData.Collections = slices.DeleteFunc(jsonData.Collections, func(c Collection) bool { return c.Id == collectionId })
+		}
 
-	decrypted, err := Decrypt(encryptedData, derivedKey)
-	if err != nil {
-		return nil, err
-	}
+		return nil
+	})
 
-	var jsonData Storage
-	err = json.Unmarshal(decrypted, &jsonData)
 	if err != nil {
-		fmt.Printf("Something went wrong while getting JSON data: %s", err)
-		return nil, err
+		return nil, fmt.Errorf("updating db: %w", err)
 	}
 
-	var foundIndex = -1
-	for i := range jsonData.Files {
-		if jsonData.Files[i].Id == fileId {
-			foundIndex = i
-			break
+	for _, blob := range unused {
+		fullFilePath := fmt.Sprintf("%s/%s.enc", dumpPath, blob)
+		if err := os.Remove(fullFilePath); err != nil {
+			return nil, fmt.Errorf("removing %s: %w", fullFilePath, err)
 		}
 	}
 
-	if foundIndex == -1 {
-		fmt.Println("file not found")
-		return nil, fmt.Errorf("file with id %s not found", fileId)
-	}
-
-	fullFilePath := fmt.Sprintf("%s/%s.enc", dumpPath, jsonData.Files[foundIndex].Id)
-
-	jsonData.Files = slices.Delete(jsonData.Files, foundIndex, foundIndex+1)
-
-	marshaledData, err := json.MarshalIndent(jsonData, "", " ")
-	if err != nil {
-		fmt.Printf("Something went wrong while marshaling JSON data: %s", err)
-		return nil, err
-	}
+	return remaining, nil
+}
 
-	encrypted, err := Encrypt(marshaledData, derivedKey)
+// ResetVault deletes the config, the db with its backup and any unfinished
+// re-key, and every blob, quarantined ones included. It holds the vault lock
+// throughout, and leaves the lock file itself for processes still waiting on
+// it.
+func ResetVault() error {
+	unlock, err := lockFile()
 	if err != nil {
-		fmt.Printf("Something went wrong while re-encrypting data: %s", err)
-		return nil, err
+		return err
 	}
-
-	if err := os.WriteFile(dbPath, encrypted, 0644); err != nil {
-		fmt.Printf("Something went wrong while writing db: %s", err)
-		return nil, err
+	defer unlock()
+
+	paths := []string{
+		filepath.Join(GetAppPaths()["userData"], "config.json"),
+		dbFilePath(),
+		dbBackupPath(),
+		dbFilePath() + rekeySuffix,
+		dumpDir(),
+		quarantineDir(),
 	}
 
-	if err := os.Remove(fullFilePath); err != nil {
-		fmt.Printf("Something went wrong while removing file: %s", err)
-		return nil, err
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
 	}
 
-	return jsonData.Files, nil
+	return nil
 }
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func storageWith(ids ...string) Storage {
+	var s Storage
+	for _, id := range ids {
+		s.Files = append(s.Files, File{Id: id})
+	}
+	return s
+}
+
+func fileIds(s Storage) string {
+	ids := ""
+	for _, file := range s.Files {
+		ids += file.Id
+	}
+	return ids
+}
+
+func TestReadStorageFallsBackToBackup(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage is done to db.enc after two writes, so db.enc.bak holds
+		// the first.
+		damage  func(t *testing.T)
+		want    string
+		wantErr bool
+	}{
+		{"intact", func(t *testing.T) {}, "ab", false},
+		{
+			name: "corrupt",
+			damage: func(t *testing.T) {
+				b, err := os.ReadFile(dbFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				b[len(b)-1] ^= 1
+				if err := os.WriteFile(dbFilePath(), b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "a",
+		},
+		{
+			name: "truncated",
+			damage: func(t *testing.T) {
+				if err := os.Truncate(dbFilePath(), headerV2Size); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "a",
+		},
+		{
+			name: "missing",
+			damage: func(t *testing.T) {
+				if err := os.Remove(dbFilePath()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "a",
+		},
+		{
+			name: "backup missing too",
+			damage: func(t *testing.T) {
+				os.Remove(dbFilePath())
+				os.Remove(dbBackupPath())
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestVault(t)
+			vaultKey := testKey(t)
+
+			for _, s := range []Storage{storageWith("a"), storageWith("a", "b")} {
+				if err := writeStorage(s, vaultKey); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.damage(t)
+
+			got, err := readStorage(vaultKey)
+			if tt.wantErr {
+				if !os.IsNotExist(err) {
+					t.Errorf("got %v, want a not-exist error", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("readStorage: %v", err)
+			}
+
+			if fileIds(got) != tt.want {
+				t.Errorf("read files %q, want %q", fileIds(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteStorageRotatesBackup(t *testing.T) {
+	newTestVault(t)
+	vaultKey := testKey(t)
+
+	steps := []struct {
+		write      Storage
+		corrupt    bool
+		wantDB     string
+		wantBackup string
+	}{
+		{write: storageWith("a"), wantDB: "a"},
+		{write: storageWith("a", "b"), wantDB: "ab", wantBackup: "a"},
+		{write: storageWith("a", "b", "c"), wantDB: "abc", wantBackup: "ab"},
+		// A db that doesn't open anymore doesn't replace the last good copy.
+		{write: storageWith("d"), corrupt: true, wantDB: "d", wantBackup: "ab"},
+	}
+
+	for i, step := range steps {
+		if step.corrupt {
+			if err := os.WriteFile(dbFilePath(), []byte("garbage"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := writeStorage(step.write, vaultKey); err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+
+		db, err := readStorageFile(dbFilePath(), vaultKey)
+		if err != nil {
+			t.Fatalf("write %d: reading db.enc: %v", i+1, err)
+		}
+
+		if fileIds(db) != step.wantDB {
+			t.Errorf("write %d: db.enc holds %q, want %q", i+1, fileIds(db), step.wantDB)
+		}
+
+		backup, err := readStorageFile(dbBackupPath(), vaultKey)
+		if step.wantBackup == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("write %d: db.enc.bak exists (%v)", i+1, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("write %d: reading db.enc.bak: %v", i+1, err)
+		}
+
+		if fileIds(backup) != step.wantBackup {
+			t.Errorf("write %d: db.enc.bak holds %q, want %q", i+1, fileIds(backup), step.wantBackup)
+		}
+	}
+}
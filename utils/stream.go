@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+/* STREAM FORMAT
+- header (see header.go)
+- followed by segments, each one chunk of plaintext sealed with AES-GCM
+
+Every segment nonce is `prefix || counter (uint32) || last flag (1 byte)`, so
+segments can't be reordered and a stream cut at a segment boundary fails to
+open because the final segment was never sealed with the last flag.
+*/
+
+const (
+	StreamChunkSize       = 64 * 1024
+	streamNoncePrefixSize = 7
+)
+
+var streamMagic = []byte("HDWY")
+
+var ErrTruncated = errors.New("encrypted stream is truncated")
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	return gcm, nil
+}
+
+func segmentNonce(nonce []byte, counter uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	} else {
+		nonce[len(nonce)-1] = 0
+	}
+}
+
+// EncryptStream seals everything read from src into dst using the chunked
+// stream format. Only one chunk is held in memory at a time. kdf records how
+// key was derived and is written to the header. context is bound to every
+// segment, the same context has to be passed to DecryptStream.
+func EncryptStream(dst io.Writer, src io.Reader, key []byte, kdf KDFParams, context []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	h := Header{
+		Version:   FormatVersion,
+		Cipher:    CipherAES256GCM,
+		KDF:       kdf,
+		ChunkSize: StreamChunkSize,
+		KeyID:     KeyID(key),
+	}
+
+	if _, err := io.ReadFull(rand.Reader, h.NoncePrefix[:]); err != nil {
+		return fmt.Errorf("generating nonce prefix: %w", err)
+	}
+
+	header := h.MarshalBinary()
+	if _, err := dst.Write(header); err != nil {
+		return fmt.Errorf("writing stream header: %w", err)
+	}
+
+	ad := append(header, context...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, h.NoncePrefix[:])
+
+	// Read one byte past the chunk so we know whether this chunk is the last.
+	buf := make([]byte, StreamChunkSize+1)
+	sealed := make([]byte, 0, StreamChunkSize+gcm.Overhead())
+
+	n, err := io.ReadFull(src, buf)
+	for counter := uint32(0); ; counter++ {
+		last := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+
+		chunk := buf[:min(n, StreamChunkSize)]
+		segmentNonce(nonce, counter, last)
+		sealed = gcm.Seal(sealed[:0], nonce, chunk, ad)
+
+		if _, err := dst.Write(sealed); err != nil {
+			return fmt.Errorf("writing segment: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+
+		if counter == math.MaxUint32 {
+			return errors.New("plaintext too large for stream format")
+		}
+
+		buf[0] = buf[StreamChunkSize]
+		n, err = io.ReadFull(src, buf[1:])
+		n++
+	}
+}
+
+// DecryptStream opens a blob written by EncryptStream, or an old single-shot
+// blob, and writes the plaintext to dst. Blobs from before version 3 carry no
+// associated data, so context is only checked for newer ones.
+func DecryptStream(dst io.Writer, src io.Reader, key []byte, context []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(src)
+	h, err := ReadHeader(br)
+	if err != nil {
+		return err
+	}
+
+	if h.Version == 0 {
+		return decryptSingleShot(dst, br, gcm)
+	}
+
+	if h.KeyID != [8]byte{} && h.KeyID != KeyID(key) {
+		return ErrWrongKey
+	}
+
+	var ad []byte
+	if h.Version >= 3 {
+		ad = append(h.MarshalBinary(), context...)
+	}
+
+	chunkSize := int(h.ChunkSize)
+
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, h.NoncePrefix[:])
+
+	segmentSize := chunkSize + gcm.Overhead()
+	buf := make([]byte, segmentSize+1)
+	plain := make([]byte, 0, chunkSize)
+
+	n, err := io.ReadFull(br, buf)
+	if err == io.EOF {
+		return ErrTruncated
+	}
+
+	for counter := uint32(0); ; counter++ {
+		last := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return fmt.Errorf("reading ciphertext: %w", err)
+		}
+
+		segmentNonce(nonce, counter, last)
+		plain, err = gcm.Open(plain[:0], nonce, buf[:min(n, segmentSize)], ad)
+		if err != nil {
+			return ErrTampered
+		}
+
+		if _, err := dst.Write(plain); err != nil {
+			return fmt.Errorf("writing plaintext: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+
+		if counter == math.MaxUint32 {
+			return errors.New("ciphertext too large for stream format")
+		}
+
+		buf[0] = buf[segmentSize]
+		n, err = io.ReadFull(br, buf[1:])
+		n++
+	}
+}
+
+func decryptSingleShot(dst io.Writer, src io.Reader, gcm cipher.AEAD) error {
+	encryptedData, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("reading encrypted file: %w", err)
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(encryptedData) < nonceSize {
+		return fmt.Errorf("encrypted data too short")
+	}
+
+	decryptedData, err := gcm.Open(nil, encryptedData[:nonceSize], encryptedData[nonceSize:], nil)
+	if err != nil {
+		return ErrTampered
+	}
+
+	if _, err := dst.Write(decryptedData); err != nil {
+		return fmt.Errorf("writing plaintext: %w", err)
+	}
+
+	return nil
+}
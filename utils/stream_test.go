@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func seal(t *testing.T, plain, key, context []byte) []byte {
+	t.Helper()
+
+	var sealed bytes.Buffer
+	if err := EncryptStream(&sealed, bytes.NewReader(plain), key, PBKDF2Params, context); err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+	return sealed.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+
+	tests := []struct {
+		name     string
+		size     int
+		segments int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"one chunk", StreamChunkSize, 1},
+		{"one chunk and a byte", StreamChunkSize + 1, 2},
+		{"several chunks", 3*StreamChunkSize + 100, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := make([]byte, tt.size)
+			rand.Read(plain)
+
+			sealed := seal(t, plain, key, BlobContext("blob"))
+
+			want := headerV2Size + tt.size + tt.segments*16
+			if len(sealed) != want {
+				t.Errorf("sealed %d bytes, want %d", len(sealed), want)
+			}
+
+			var opened bytes.Buffer
+			if err := DecryptStream(&opened, bytes.NewReader(sealed), key, BlobContext("blob")); err != nil {
+				t.Fatalf("DecryptStream: %v", err)
+			}
+
+			if !bytes.Equal(opened.Bytes(), plain) {
+				t.Errorf("got %d bytes back, want the %d that went in", opened.Len(), len(plain))
+			}
+		})
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	segment := StreamChunkSize + 16
+
+	plain := make([]byte, 2*StreamChunkSize+10)
+	rand.Read(plain)
+	sealed := seal(t, plain, key, BlobContext("blob"))
+
+	tests := []struct {
+		name    string
+		tamper  func(b []byte) []byte
+		wantErr error
+	}{
+		{
+			name:    "header only",
+			tamper:  func(b []byte) []byte { return b[:headerV2Size] },
+			wantErr: ErrTruncated,
+		},
+		{
+			name:    "cut inside the header",
+			tamper:  func(b []byte) []byte { return b[:headerV2Size-3] },
+			wantErr: ErrTruncated,
+		},
+		{
+			name:    "cut at a segment boundary",
+			tamper:  func(b []byte) []byte { return b[:headerV2Size+segment] },
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "cut inside the last segment",
+			tamper:  func(b []byte) []byte { return b[:len(b)-1] },
+			wantErr: ErrTampered,
+		},
+		{
+			name: "segments swapped",
+			tamper: func(b []byte) []byte {
+				first := headerV2Size
+				second := first + segment
+				out := append([]byte{}, b[:first]...)
+				out = append(out, b[second:second+segment]...)
+				out = append(out, b[first:second]...)
+				return append(out, b[second+segment:]...)
+			},
+			wantErr: ErrTampered,
+		},
+		{
+			name: "segment changed",
+			tamper: func(b []byte) []byte {
+				b[headerV2Size+segment+5] ^= 1
+				return b
+			},
+			wantErr: ErrTampered,
+		},
+		{
+			name: "segment dropped",
+			tamper: func(b []byte) []byte {
+				return append(b[:headerV2Size+segment], b[headerV2Size+2*segment:]...)
+			},
+			wantErr: ErrTampered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(bytes.Clone(sealed))
+
+			err := DecryptStream(&bytes.Buffer{}, bytes.NewReader(tampered), key, BlobContext("blob"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestWriter counts, hashes and MACs the plaintext of a file as it streams
+// through the cipher, so sizes and checksums cost no extra pass.
+type digestWriter struct {
+	hash hash.Hash
+	mac  hash.Hash
+	n    int64
+}
+
+func newDigestWriter(vaultKey []byte) *digestWriter {
+	return &digestWriter{hash: sha256.New(), mac: newContentMAC(vaultKey)}
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	d.hash.Write(p)
+	d.mac.Write(p)
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+func (d *digestWriter) Sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+func (d *digestWriter) MAC() string {
+	return hex.EncodeToString(d.mac.Sum(nil))
+}
+
+// fillFileInfo decrypts the file's blob to measure it and sets Size,
+// CipherSize, SHA256 and ContentMAC on the record. It fails if the blob doesn't
+// open.
+func fillFileInfo(file *File, vaultKey []byte) error {
+	return measureContent(&file.Content, file.Blob(), vaultKey)
+}
+
+// measureContent does what fillFileInfo does for any of a file's contents,
+// stored in the given blob.
+func measureContent(c *Content, blob string, vaultKey []byte) error {
+	contentKey, err := c.ContentKey(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(blobPath(blob))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	digest := newDigestWriter(vaultKey)
+	if err := DecryptStream(digest, f, contentKey, BlobContext(blob)); err != nil {
+		return err
+	}
+
+	c.Size = digest.n
+	c.CipherSize = info.Size()
+	c.SHA256 = digest.Sum()
+	c.ContentMAC = digest.MAC()
+
+	return nil
+}
+
+func needsInfo(c Content) bool {
+	return c.SHA256 == "" || c.ContentMAC == ""
+}
+
+// backfillFileInfo measures every record and old version added before sizes
+// and checksums were stored, or whose content MAC was cleared by a re-key.
+// Blobs that don't open are left for fsck to report.
+//
+// Decrypting everything can take a while, so it is done without the vault
+// lock, which is only taken to merge the results into the current db.
+func backfillFileInfo(vaultKey []byte) error {
+	data, err := readStorage(vaultKey)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Measured contents by blob, shared blobs are only decrypted once.
+	measured := map[string]Content{}
+	for i := range data.Files {
+		file := &data.Files[i]
+
+		for _, c := range file.contents() {
+			blob := file.blobOf(*c)
+			if _, ok := measured[blob]; ok || !needsInfo(*c) {
+				continue
+			}
+
+			if err := measureContent(c, blob, vaultKey); err == nil {
+				measured[blob] = *c
+			}
+		}
+	}
+
+	if len(measured) == 0 {
+		return nil
+	}
+
+	return mutateStorage(vaultKey, func(data *Storage) error {
+		for i := range data.Files {
+			file := &data.Files[i]
+
+			for _, c := range file.contents() {
+				if m, ok := measured[file.blobOf(*c)]; ok && needsInfo(*c) {
+					c.Size, c.CipherSize, c.SHA256, c.ContentMAC = m.Size, m.CipherSize, m.SHA256, m.ContentMAC
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Total adds up the files that share a MIME type, an extension, ...
+type Total struct {
+	Key   string
+	Count int
+	Size  int64
+}
+
+// PeriodTotal adds up the files added in a week or month starting at Start.
+// Period is like "2025-03" for months and "2025-W09" for ISO weeks.
+type PeriodTotal struct {
+	Period string
+	Start  time.Time
+	Count  int
+	Size   int64
+}
+
+// VaultSummary adds up the sizes of everything in the vault.
+type VaultSummary struct {
+	Files int
+	Size  int64
+	// CipherSize counts every blob once, those of old versions included.
+	CipherSize  int64
+	OldVersions int
+	// Largest holds the biggest files, biggest first.
+	Largest []File
+	// Oldest and Newest are the first and last files added, nil without any.
+	Oldest *File
+	Newest *File
+	// ByMime and ByExtension are sorted by total size, biggest first.
+	ByMime      []Total
+	ByExtension []Total
+	// ByMonth and ByWeek are sorted by date, oldest first, with the periods
+	// nothing was added in included.
+	ByMonth []PeriodTotal
+	ByWeek  []PeriodTotal
+	// Disk is left for the caller to fill in, see MeasureDump.
+	Disk DiskUsage
+}
+
+// Overhead is how much more `dump/` takes up than the files in it, for
+// headers, tags and old versions, or less when shared blobs save space.
+func (s VaultSummary) Overhead() int64 {
+	return s.Disk.Bytes - s.Size
+}
+
+// Summarize builds a VaultSummary of files, keeping the top largest of them.
+func Summarize(files []File, top int) VaultSummary {
+	top = max(top, 0)
+	summary := VaultSummary{Files: len(files)}
+	byMime := map[string]*Total{}
+	byExtension := map[string]*Total{}
+	counted := map[string]bool{}
+
+	for i, file := range files {
+		summary.Size += file.Size
+		summary.OldVersions += len(file.Versions)
+
+		for _, c := range file.contents() {
+			if blob := file.blobOf(*c); !counted[blob] {
+				counted[blob] = true
+				summary.CipherSize += c.CipherSize
+			}
+		}
+
+		addTotal(byMime, file.MimeType, file.Size)
+		addTotal(byExtension, strings.ToLower(file.Extension), file.Size)
+
+		if summary.Oldest == nil || file.DateAdded.Before(summary.Oldest.DateAdded) {
+			summary.Oldest = &files[i]
+		}
+		if summary.Newest == nil || !file.DateAdded.Before(summary.Newest.DateAdded) {
+			summary.Newest = &files[i]
+		}
+	}
+
+	summary.Largest = append([]File{}, files...)
+	sort.SliceStable(summary.Largest, func(i, j int) bool {
+		return summary.Largest[i].Size > summary.Largest[j].Size
+	})
+	if len(summary.Largest) > top {
+		summary.Largest = summary.Largest[:top]
+	}
+
+	summary.ByMime = sortTotals(byMime)
+	summary.ByExtension = sortTotals(byExtension)
+	summary.ByMonth = addedPer(files, monthOf)
+	summary.ByWeek = addedPer(files, weekOf)
+
+	return summary
+}
+
+func addTotal(totals map[string]*Total, key string, size int64) {
+	total, ok := totals[key]
+	if !ok {
+		total = &Total{Key: key}
+		totals[key] = total
+	}
+	total.Count++
+	total.Size += size
+}
+
+func sortTotals(totals map[string]*Total) []Total {
+	var sorted []Total
+	for _, total := range totals {
+		sorted = append(sorted, *total)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Size != sorted[j].Size {
+			return sorted[i].Size > sorted[j].Size
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	return sorted
+}
+
+// monthOf and weekOf return the period t falls in and the start of the next.
+func monthOf(t time.Time) (string, time.Time, time.Time) {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return start.Format("2006-01"), start, start.AddDate(0, 1, 0)
+}
+
+func weekOf(t time.Time) (string, time.Time, time.Time) {
+	// ISO weeks start on Monday.
+	offset := (int(t.Weekday()) + 6) % 7
+	start := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+
+	year, week := start.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week), start, start.AddDate(0, 0, 7)
+}
+
+// addedPer totals the files by the period they were added in, from the
+// first period to the last.
+func addedPer(files []File, period func(time.Time) (string, time.Time, time.Time)) []PeriodTotal {
+	if len(files) == 0 {
+		return nil
+	}
+
+	totals := map[string]*PeriodTotal{}
+	var first, last time.Time
+
+	for _, file := range files {
+		added := file.DateAdded.Local()
+		name, start, _ := period(added)
+
+		total, ok := totals[name]
+		if !ok {
+			total = &PeriodTotal{Period: name, Start: start}
+			totals[name] = total
+		}
+		total.Count++
+		total.Size += file.Size
+
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+
+	var periods []PeriodTotal
+	for start := first; !start.After(last); {
+		name, _, next := period(start)
+		if total, ok := totals[name]; ok {
+			periods = append(periods, *total)
+		} else {
+			periods = append(periods, PeriodTotal{Period: name, Start: start})
+		}
+		start = next
+	}
+
+	return periods
+}
+
+// DiskUsage is what `dump/` takes up on disk.
+type DiskUsage struct {
+	Files int
+	Bytes int64
+}
+
+// MeasureDump adds up the files in `dump/`, leftovers of interrupted writes
+// included.
+func MeasureDump() (DiskUsage, error) {
+	var usage DiskUsage
+
+	entries, err := os.ReadDir(dumpDir())
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		usage.Files++
+		usage.Bytes += info.Size()
+	}
+
+	return usage, nil
+}
+
+// FormatSize renders a byte count for humans, e.g. "1.5 MiB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
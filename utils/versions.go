@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+/* VERSIONS
+- A file's current contents are version File.Version (1 if unset), older
+  contents are kept in File.Versions, oldest first, each with its number.
+  Numbers never change, pruning only leaves gaps.
+- Replacing the contents (edit, adding a changed file again, restore) moves
+  the current contents to the end of Versions. Their blob stays, the version
+  references it now.
+- The retention policy in the config drops old versions, their blobs go once
+  nothing else uses them.
+*/
+
+type Version struct {
+	Content
+	Number       int       `json:"number"`
+	DateAdded    time.Time `json:"date_added"`
+	DateReplaced time.Time `json:"date_replaced"`
+}
+
+// RetentionPolicy limits the old versions kept of every file. Zero means no
+// limit.
+type RetentionPolicy struct {
+	Keep int
+	Days int
+}
+
+func (c Config) Retention() RetentionPolicy {
+	return RetentionPolicy{Keep: c.KeepVersions, Days: c.KeepVersionsDays}
+}
+
+func (f File) CurrentVersion() int {
+	if f.Version == 0 {
+		return 1
+	}
+	return f.Version
+}
+
+// Modified is when the current contents were stored.
+func (f File) Modified() time.Time {
+	if f.DateModified.IsZero() {
+		return f.DateAdded
+	}
+	return f.DateModified
+}
+
+// AtVersion returns the file as it was at version n.
+func (f File) AtVersion(n int) (File, error) {
+	if n == f.CurrentVersion() {
+		return f, nil
+	}
+
+	for _, v := range f.Versions {
+		if v.Number == n {
+			old := f
+			old.Content = v.Content
+			old.Version = v.Number
+			old.DateModified = v.DateAdded
+			old.Versions = nil
+			return old, nil
+		}
+	}
+
+	return File{}, fmt.Errorf("version %d of %s %w", n, f.OriginalName, ErrNotFound)
+}
+
+// contents lists the current contents and every old version of the file.
+func (f *File) contents() []*Content {
+	all := []*Content{&f.Content}
+	for i := range f.Versions {
+		all = append(all, &f.Versions[i].Content)
+	}
+
+	return all
+}
+
+// blobOf returns the ID of the blob holding c, one of the file's contents.
+func (f File) blobOf(c Content) string {
+	if c.BlobId != "" {
+		return c.BlobId
+	}
+	return f.Id
+}
+
+// blobs lists every blob the file uses, the current one first.
+func (f File) blobs() []string {
+	var blobs []string
+	for _, c := range f.contents() {
+		blobs = append(blobs, f.blobOf(*c))
+	}
+	return blobs
+}
+
+// setContent makes c the current contents of file and keeps the old ones as a
+// version. It returns the blobs that the retention policy made redundant.
+func (s *Storage) setContent(file *File, c Content, policy RetentionPolicy) []string {
+	if s.RefCounts == nil {
+		s.countRefs()
+	}
+
+	now := time.Now()
+
+	old := file.Content
+	old.BlobId = file.Blob()
+
+	file.Versions = append(file.Versions, Version{
+		Content:      old,
+		Number:       file.CurrentVersion(),
+		DateAdded:    file.Modified(),
+		DateReplaced: now,
+	})
+
+	file.Version = file.CurrentVersion() + 1
+	file.Content = c
+	if file.BlobId == file.Id {
+		file.BlobId = ""
+	}
+	file.DateModified = now
+	s.RefCounts[file.Blob()]++
+
+	return s.pruneVersions(file, policy, now)
+}
+
+// pruneVersions drops the versions of file the policy doesn't keep and
+// returns the blobs nothing uses anymore.
+func (s *Storage) pruneVersions(file *File, policy RetentionPolicy, now time.Time) []string {
+	var kept []Version
+	var redundant []string
+
+	for i, v := range file.Versions {
+		tooMany := policy.Keep > 0 && len(file.Versions)-i > policy.Keep
+		tooOld := policy.Days > 0 && now.Sub(v.DateReplaced) > time.Duration(policy.Days)*24*time.Hour
+
+		if !tooMany && !tooOld {
+			kept = append(kept, v)
+			continue
+		}
+
+		if s.unref(v.BlobId) {
+			redundant = append(redundant, v.BlobId)
+		}
+	}
+
+	file.Versions = kept
+	return redundant
+}
+
+// RestoreVersion makes version n of the file the current one again. The
+// contents it replaces are kept as a new version, so nothing is lost.
+func RestoreVersion(fileId string, n int, vaultKey []byte) (File, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return File{}, fmt.Errorf("reading config: %w", err)
+	}
+
+	var restored File
+	var redundant []string
+
+	err = mutateStorage(vaultKey, func(data *Storage) error {
+		found, err := findFile(data.Files, fileId)
+		if err != nil {
+			return err
+		}
+
+		if n == found.CurrentVersion() {
+			return fmt.Errorf("version %d is already the current one", n)
+		}
+
+		old, err := found.AtVersion(n)
+		if err != nil {
+			return err
+		}
+
+		redundant = data.setContent(found, old.Content, config.Retention())
+		restored = *found
+		return nil
+	})
+
+	if err != nil {
+		return File{}, err
+	}
+
+	removeBlobs(redundant)
+
+	return restored, nil
+}
+
+// PruneVersions applies the retention policy to every file, for when it was
+// tightened. It returns how many versions were dropped.
+func PruneVersions(vaultKey []byte) (int, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return 0, fmt.Errorf("reading config: %w", err)
+	}
+
+	pruned := 0
+	var redundant []string
+
+	err = mutateStorage(vaultKey, func(data *Storage) error {
+		if data.RefCounts == nil {
+			data.countRefs()
+		}
+
+		now := time.Now()
+		for i := range data.Files {
+			before := len(data.Files[i].Versions)
+			redundant = append(redundant, data.pruneVersions(&data.Files[i], config.Retention(), now)...)
+			pruned += before - len(data.Files[i].Versions)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	removeBlobs(redundant)
+
+	return pruned, nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPruneVersions(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	// Versions 1 to 4 of a.txt, replaced 10, 5 and 2 days and an hour ago.
+	// Version 1 has the same contents as b.txt.
+	newStorage := func() Storage {
+		s := Storage{Files: []File{
+			{
+				Id:      "a",
+				Version: 5,
+				Versions: []Version{
+					{Content: Content{BlobId: "b"}, Number: 1, DateReplaced: now.Add(-10 * day)},
+					{Content: Content{BlobId: "v2"}, Number: 2, DateReplaced: now.Add(-5 * day)},
+					{Content: Content{BlobId: "v3"}, Number: 3, DateReplaced: now.Add(-2 * day)},
+					{Content: Content{BlobId: "v4"}, Number: 4, DateReplaced: now.Add(-time.Hour)},
+				},
+			},
+			{Id: "b"},
+		}}
+		s.countRefs()
+		return s
+	}
+
+	tests := []struct {
+		name          string
+		policy        RetentionPolicy
+		wantKept      []int
+		wantRedundant []string
+	}{
+		{"no limit", RetentionPolicy{}, []int{1, 2, 3, 4}, nil},
+		{"keep 2", RetentionPolicy{Keep: 2}, []int{3, 4}, []string{"v2"}},
+		{"keep more than there are", RetentionPolicy{Keep: 10}, []int{1, 2, 3, 4}, nil},
+		{"3 days", RetentionPolicy{Days: 3}, []int{3, 4}, []string{"v2"}},
+		{"7 days", RetentionPolicy{Days: 7}, []int{2, 3, 4}, nil},
+		{"keep 1 within 3 days", RetentionPolicy{Keep: 1, Days: 3}, []int{4}, []string{"v2", "v3"}},
+		{"keep 3 within 3 days", RetentionPolicy{Keep: 3, Days: 3}, []int{3, 4}, []string{"v2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage()
+			file := &s.Files[0]
+
+			redundant := s.pruneVersions(file, tt.policy, now)
+
+			var kept []int
+			for _, v := range file.Versions {
+				kept = append(kept, v.Number)
+			}
+
+			if !slices.Equal(kept, tt.wantKept) {
+				t.Errorf("kept versions %v, want %v", kept, tt.wantKept)
+			}
+
+			// b.txt still uses version 1's blob.
+			if !slices.Equal(redundant, tt.wantRedundant) {
+				t.Errorf("redundant blobs %v, want %v", redundant, tt.wantRedundant)
+			}
+
+			if file.CurrentVersion() != 5 {
+				t.Errorf("current version changed to %d", file.CurrentVersion())
+			}
+		})
+	}
+}
+
+func TestPruneVersionsRemovesBlobs(t *testing.T) {
+	newTestVault(t)
+	vaultKey := testKey(t)
+
+	var blobs []string
+	for _, contents := range []string{"one", "two", "three"} {
+		file, err := EncryptReader(bytes.NewReader([]byte(contents)), dumpDir(), "a.txt", vaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		added, err := AppendFiles([]File{file}, vaultKey, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, added[0].File.Blob())
+	}
+
+	if err := WriteConfig(Config{KeepVersions: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := PruneVersions(vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pruned != 1 {
+		t.Errorf("pruned %d versions, want 1", pruned)
+	}
+
+	for i, blob := range blobs {
+		_, err := os.Stat(blobPath(blob))
+		if exists := err == nil; exists != (i > 0) {
+			t.Errorf("blob of version %d exists is %v, want %v", i+1, exists, i > 0)
+		}
+	}
+
+	var got bytes.Buffer
+	if err := StreamFile("a.txt", vaultKey, &got); err != nil || got.String() != "three" {
+		t.Errorf("a.txt holds %q (%v), want %q", got.String(), err, "three")
+	}
+}